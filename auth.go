@@ -1,17 +1,33 @@
 package cloudyaws
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"os"
 	"strings"
+	"time"
 
+	"github.com/appliedres/cloudy"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
-
-	"github.com/appliedres/cloudy"
+	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/endpointcreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	credentialsv1 "github.com/aws/aws-sdk-go/aws/credentials"
 )
 
 const DefaultRegion = "us-gov-east-1"
 
+// DefaultProfile is the shared config profile used by the CLI credential types when none is set
+const DefaultProfile = "default"
+
+// Address of the ECS agent that serves AWS_CONTAINER_CREDENTIALS_RELATIVE_URI
+const ecsContainerHost = "http://169.254.170.2"
+
 type AwsCredentials struct {
 	Type            string // Can be any type of CredType*
 	Region          string
@@ -19,6 +35,49 @@ type AwsCredentials struct {
 	SecretAccessKey string
 	SessionToken    string
 	Location        string
+
+	// Shared config profile for the default chain and the CLI / SSO types
+	Profile string
+
+	// Role settings used by CredTypeWebIdentity and CredTypeAssumeRole
+	RoleArn              string
+	SessionName          string
+	ExternalId           string
+	DurationSeconds      int32
+	WebIdentityTokenFile string
+
+	// MFA device for CredTypeAssumeRole, MFATokenProvider is required with it. CLI callers can
+	// use stscreds.StdinTokenProvider to prompt for the token.
+	MFASerial        string
+	MFATokenProvider func() (string, error)
+
+	// SourceType is the credential type used to call AssumeRole, RoleChain adds more hops after RoleArn
+	SourceType string
+	RoleChain  []AwsRole
+
+	// Overrides for the instance metadata and container credential endpoints
+	IMDSEndpoint      string
+	ContainerEndpoint string
+
+	// Client settings applied by NewAwsConfig and NewAwsSession
+	Endpoint    string       // Base endpoint URL for every service
+	RetryMode   string       // "standard" or "adaptive", v1 clients only take "standard"
+	MaxAttempts int          // Total attempts per request, including the first
+	HTTPClient  *http.Client // Custom transport, proxies or test servers
+
+	// Per service endpoint overrides keyed by service name, see EndpointFor
+	Endpoints map[string]string
+	// Path style S3 addressing, needed by LocalStack and most S3 emulators
+	S3ForcePathStyle bool
+
+	// Partition the region must belong to, empty accepts any, see ResolveRegion
+	Partition    string
+	UseFIPS      bool
+	UseDualStack bool
+
+	// Checks run when a provider is configured, see ValidateAwsCredentials
+	ValidateIdentity    bool // Call STS GetCallerIdentity
+	ValidatePermissions bool // Also simulate the IAM actions the provider needs
 }
 
 const (
@@ -33,52 +92,35 @@ const (
 	CredTypeStatic  = "static"
 	CredTypeIAMRole = "iam"
 	CredTypeOther   = "other"
-)
 
-const (
-	RegionPublic            = "public"
-	RegionUSGovernment      = "usgovernment"
-	RegionAzureUSGovernment = "azureusgoverment"
+	CredTypeContainer   = "container"
+	CredTypeWebIdentity = "webidentity"
+	CredTypeAssumeRole  = "assumerole"
 )
 
-func fixRegionName(regionName string) string {
-	regionNameFixed := strings.ToLower(regionName)
-	regionNameFixed = strings.ReplaceAll(regionNameFixed, "-", "")
-	regionNameFixed = strings.ReplaceAll(regionNameFixed, "_", "")
-	return regionNameFixed
+// AwsRole is a single hop in an assume-role chain
+type AwsRole struct {
+	RoleArn         string
+	SessionName     string
+	ExternalId      string
+	DurationSeconds int32
+	MFASerial       string
 }
 
-// func PolicyFromRegionString(regionName string) cloud.Configuration {
-// 	regionNameFixed := fixRegionName(regionName)
-
-// 	switch regionNameFixed {
-// 	// Default to the government region
-// 	case "":
-// 		return cloud.AzureGovernment
-// 	case RegionUSGovernment:
-// 		return cloud.AzureGovernment
-// 	case RegionAzureUSGovernment:
-// 		return cloud.AzureGovernment
-// 	case RegionPublic:
-// 		return cloud.AzurePublic
-// 	default:
-// 		// Not sure WHAT to do with a custom.. Just assume it is the authority?
-// 		// Needs to match "https://login.microsoftonline.com/"
-// 		customRegion := regionName
-// 		if !strings.HasPrefix(customRegion, "https://") {
-// 			customRegion = fmt.Sprintf("https://%v", customRegion)
-// 		}
-// 		if !strings.HasSuffix(customRegion, "/") {
-// 			customRegion = fmt.Sprintf("%v/", customRegion)
-// 		}
-// 		return cloud.Configuration{
-// 			ActiveDirectoryAuthorityHost: customRegion,
-// 			Services:                     map[cloud.ServiceName]cloud.ServiceConfiguration{},
-// 		}
-// 	}
-// }
+// Assumed role credentials are refreshed this long before they expire
+const roleExpiryWindow = time.Minute
 
+// Region aliases understood by ResolveRegion
+const (
+	RegionPublic       = "public"
+	RegionUSGovernment = "usgovernment"
+)
+
+// NewAwsCredentials maps the credential type onto an aws-sdk-go-v2 credentials provider.
+// When no type is given, static keys are used if present and the default chain otherwise.
 func NewAwsCredentials(awsCred *AwsCredentials) (aws.CredentialsProvider, error) {
+	ctx := context.Background()
+
 	credType := strings.ToLower(awsCred.Type)
 	if credType == "" {
 		if awsCred.AccessKeyID != "" && awsCred.SecretAccessKey != "" {
@@ -89,59 +131,263 @@ func NewAwsCredentials(awsCred *AwsCredentials) (aws.CredentialsProvider, error)
 	}
 
 	switch credType {
-	case CredTypeStatic:
+	case CredTypeStatic, CredTypeSecret:
+		if awsCred.AccessKeyID == "" || awsCred.SecretAccessKey == "" {
+			return nil, fmt.Errorf("credential type %v requires an access key id and secret access key", credType)
+		}
 		return credentials.NewStaticCredentialsProvider(
 			awsCred.AccessKeyID,
 			awsCred.SecretAccessKey,
 			awsCred.SessionToken,
 		), nil
 
-	// case CredTypeEnv:
-	// 	// Environment-based credentials provider
-	// 	return aws.NewCredentialsCache(credentials.NewEnvironmentCredential()), nil
+	case CredTypeEnv:
+		envCfg, err := config.NewEnvConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read AWS environment: %w", err)
+		}
+		if !envCfg.Credentials.HasKeys() {
+			return nil, fmt.Errorf("credential type %v requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY", credType)
+		}
+		return credentials.StaticCredentialsProvider{Value: envCfg.Credentials}, nil
 
-	// case CredTypeDefault:
-	// 	// Default credential chain provider
-	// 	cfg, err := config.LoadDefaultConfig(context.Background())
-	// 	if err != nil {
-	// 		return nil, fmt.Errorf("failed to load default AWS config: %w", err)
-	// 	}
-	// 	return cfg.Credentials, nil
+	case CredTypeDefault, CredTypeOther:
+		// The full SDK chain: env, shared config, web identity, container and instance role
+		cfg, err := config.LoadDefaultConfig(ctx, defaultConfigOptions(awsCred)...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load default AWS config: %w", err)
+		}
+		return cfg.Credentials, nil
+
+	case CredTypeCli, CredTypeDevCli, CredTypeCode, CredTypeBrowser:
+		// Credentials written by the AWS CLI, including SSO sessions from `aws sso login`
+		profile := awsCred.Profile
+		if profile == "" {
+			profile = DefaultProfile
+		}
+		opts := append(defaultConfigOptions(awsCred), config.WithSharedConfigProfile(profile))
+		cfg, err := config.LoadDefaultConfig(ctx, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load AWS profile %v: %w", profile, err)
+		}
+		return cfg.Credentials, nil
+
+	case CredTypeIAMRole:
+		return newInstanceRoleProvider(awsCred), nil
+
+	case CredTypeContainer:
+		return newContainerProvider(awsCred)
+
+	case CredTypeManaged:
+		// Whatever role the compute platform hands out, ECS task role first then the instance profile
+		if containerCredentialsEndpoint(awsCred) != "" {
+			return newContainerProvider(awsCred)
+		}
+		return newInstanceRoleProvider(awsCred), nil
+
+	case CredTypeWebIdentity:
+		return newWebIdentityProvider(awsCred)
+
+	case CredTypeAssumeRole:
+		return newAssumeRoleProvider(awsCred)
 
 	default:
 		return nil, fmt.Errorf("unknown credential type: %v", credType)
 	}
 }
 
-// func GetAzureClientSecretCredential(azCfg AzureCredentials) (*azidentity.ClientSecretCredential, error) {
-
-// 	cred, err := azidentity.NewClientSecretCredential(azCfg.TenantID, azCfg.ClientID, azCfg.ClientSecret,
-// 		&azidentity.ClientSecretCredentialOptions{
-// 			ClientOptions: policy.ClientOptions{
-// 				Cloud: cloud.AzureGovernment,
-// 			},
-// 		})
-
-// 	if err != nil {
-// 		fmt.Printf("GetAzureCredentials Error authentication provider: %v\n", err)
-// 		return nil, err
-// 	}
-
-// 	return cred, err
-// }
-
-func GetAzureCredentialsFromEnv(env *cloudy.Environment) AwsCredentials {
-	// Check to see if there is already a set of credentials
-	creds := env.GetCredential(AwsCredentialsKey)
-	if creds != nil {
-		return creds.(AwsCredentials)
-	}
-	credentials := AwsCredentials{
-		Region:          env.Default("AWS_REGION", DefaultRegion),
-		Type:            env.Default("AWS_CRED_TYPE", ""),
-		AccessKeyID:     env.Default("AWS_ACCESS_KEY", ""),
-		SecretAccessKey: env.Default("AWS_SECRET_KEY", ""),
-		Location:        env.Default("AWS_LOCATION", ""),
-	}
-	return credentials
+func defaultConfigOptions(awsCred *AwsCredentials) []func(*config.LoadOptions) error {
+	var opts []func(*config.LoadOptions) error
+	if awsCred.Region != "" {
+		opts = append(opts, config.WithRegion(awsCred.Region))
+	}
+	if awsCred.Profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(awsCred.Profile))
+	}
+	if awsCred.IMDSEndpoint != "" {
+		opts = append(opts, config.WithEC2IMDSEndpoint(awsCred.IMDSEndpoint))
+	}
+	return opts
+}
+
+func newInstanceRoleProvider(awsCred *AwsCredentials) aws.CredentialsProvider {
+	provider := ec2rolecreds.New(func(o *ec2rolecreds.Options) {
+		if awsCred.IMDSEndpoint != "" {
+			o.Client = imds.New(imds.Options{Endpoint: awsCred.IMDSEndpoint})
+		}
+	})
+	return aws.NewCredentialsCache(provider)
+}
+
+// containerCredentialsEndpoint follows the ECS agent conventions, a relative URI against the
+// link-local agent address or a full URI for other container hosts.
+func containerCredentialsEndpoint(awsCred *AwsCredentials) string {
+	if awsCred.ContainerEndpoint != "" {
+		return awsCred.ContainerEndpoint
+	}
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		return ecsContainerHost + relative
+	}
+	return os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+}
+
+func newContainerProvider(awsCred *AwsCredentials) (aws.CredentialsProvider, error) {
+	endpoint := containerCredentialsEndpoint(awsCred)
+	if endpoint == "" {
+		return nil, fmt.Errorf("credential type %v requires AWS_CONTAINER_CREDENTIALS_RELATIVE_URI or AWS_CONTAINER_CREDENTIALS_FULL_URI", awsCred.Type)
+	}
+
+	provider := endpointcreds.New(endpoint, func(o *endpointcreds.Options) {
+		if tokenFile := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); tokenFile != "" {
+			o.AuthorizationTokenProvider = endpointcreds.TokenProviderFunc(func() (string, error) {
+				token, err := os.ReadFile(tokenFile)
+				return strings.TrimSpace(string(token)), err
+			})
+		} else {
+			o.AuthorizationToken = os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+		}
+	})
+	return aws.NewCredentialsCache(provider), nil
+}
+
+func newWebIdentityProvider(awsCred *AwsCredentials) (aws.CredentialsProvider, error) {
+	roleArn := awsCred.RoleArn
+	if roleArn == "" {
+		roleArn = os.Getenv("AWS_ROLE_ARN")
+	}
+	tokenFile := awsCred.WebIdentityTokenFile
+	if tokenFile == "" {
+		tokenFile = os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	}
+	if roleArn == "" || tokenFile == "" {
+		return nil, fmt.Errorf("credential type %v requires a role ARN and web identity token file", awsCred.Type)
+	}
+
+	client, err := newStsClient(awsCred, aws.AnonymousCredentials{})
+	if err != nil {
+		return nil, err
+	}
+
+	provider := stscreds.NewWebIdentityRoleProvider(client, roleArn, stscreds.IdentityTokenFile(tokenFile),
+		func(o *stscreds.WebIdentityRoleOptions) {
+			o.RoleSessionName = awsCred.SessionName
+		})
+	return aws.NewCredentialsCache(provider), nil
+}
+
+// newAssumeRoleProvider resolves the source credentials and then assumes each role in turn,
+// every hop signing its AssumeRole call with the credentials of the hop before it.
+func newAssumeRoleProvider(awsCred *AwsCredentials) (aws.CredentialsProvider, error) {
+	var roles []AwsRole
+	if awsCred.RoleArn != "" {
+		roles = append(roles, AwsRole{
+			RoleArn:         awsCred.RoleArn,
+			SessionName:     awsCred.SessionName,
+			ExternalId:      awsCred.ExternalId,
+			DurationSeconds: awsCred.DurationSeconds,
+			MFASerial:       awsCred.MFASerial,
+		})
+	}
+	roles = append(roles, awsCred.RoleChain...)
+	if len(roles) == 0 {
+		return nil, fmt.Errorf("credential type %v requires a role ARN", awsCred.Type)
+	}
+
+	if strings.EqualFold(awsCred.SourceType, CredTypeAssumeRole) {
+		return nil, fmt.Errorf("source credential type cannot be %v, use RoleChain instead", CredTypeAssumeRole)
+	}
+	source := *awsCred
+	source.Type = awsCred.SourceType
+	provider, err := NewAwsCredentials(&source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load source credentials for assume role: %w", err)
+	}
+
+	for _, role := range roles {
+		if role.RoleArn == "" {
+			return nil, fmt.Errorf("every role in the chain requires a role ARN")
+		}
+		if role.MFASerial != "" && awsCred.MFATokenProvider == nil {
+			return nil, fmt.Errorf("role %v requires an MFA token provider: %w", role.RoleArn, cloudy.ErrInvalidConfiguration)
+		}
+
+		client, err := newStsClient(awsCred, provider)
+		if err != nil {
+			return nil, err
+		}
+
+		role := role
+		assumed := stscreds.NewAssumeRoleProvider(client, role.RoleArn, func(o *stscreds.AssumeRoleOptions) {
+			if role.SessionName != "" {
+				o.RoleSessionName = role.SessionName
+			}
+			if role.ExternalId != "" {
+				o.ExternalID = aws.String(role.ExternalId)
+			}
+			if role.DurationSeconds > 0 {
+				o.Duration = time.Duration(role.DurationSeconds) * time.Second
+			}
+			if role.MFASerial != "" {
+				o.SerialNumber = aws.String(role.MFASerial)
+				o.TokenProvider = awsCred.MFATokenProvider
+			}
+		})
+		provider = aws.NewCredentialsCache(assumed, func(o *aws.CredentialsCacheOptions) {
+			o.ExpiryWindow = roleExpiryWindow
+		})
+	}
+
+	return provider, nil
+}
+
+// newStsClient builds the STS client used while resolving credentials from the same settings
+// as every other client. AWS_ENDPOINT_URL_STS can also point it at a local stub.
+func newStsClient(awsCred *AwsCredentials, provider aws.CredentialsProvider) (*sts.Client, error) {
+	cfg, err := loadAwsConfig(context.Background(), awsCred, provider)
+	if err != nil {
+		return nil, err
+	}
+	return sts.NewFromConfig(cfg, func(o *sts.Options) {
+		if url := awsCred.EndpointFor(sts.ServiceID); url != "" {
+			o.BaseEndpoint = aws.String(url)
+		}
+	}), nil
+}
+
+// NewAwsCredentialsV1 resolves the same credential types for the aws-sdk-go v1 clients
+func NewAwsCredentialsV1(awsCred *AwsCredentials) (*credentialsv1.Credentials, error) {
+	provider, err := NewAwsCredentials(awsCred)
+	if err != nil {
+		return nil, err
+	}
+	return credentialsv1.NewCredentials(&v1CredentialsAdapter{provider: provider}), nil
+}
+
+// v1CredentialsAdapter lets a v2 provider, and its refresh logic, back a v1 session
+type v1CredentialsAdapter struct {
+	provider aws.CredentialsProvider
+	current  aws.Credentials
+}
+
+func (a *v1CredentialsAdapter) Retrieve() (credentialsv1.Value, error) {
+	return a.RetrieveWithContext(context.Background())
+}
+
+func (a *v1CredentialsAdapter) RetrieveWithContext(ctx credentialsv1.Context) (credentialsv1.Value, error) {
+	creds, err := a.provider.Retrieve(ctx)
+	if err != nil {
+		return credentialsv1.Value{}, err
+	}
+	a.current = creds
+
+	return credentialsv1.Value{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+		ProviderName:    creds.Source,
+	}, nil
+}
+
+func (a *v1CredentialsAdapter) IsExpired() bool {
+	return !a.current.HasKeys() || a.current.Expired()
 }
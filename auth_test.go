@@ -0,0 +1,327 @@
+package cloudyaws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appliedres/cloudy"
+	"github.com/stretchr/testify/assert"
+)
+
+func fakeCredentialsBody(accessKey string) map[string]string {
+	return map[string]string{
+		"Code":            "Success",
+		"Type":            "AWS-HMAC",
+		"AccessKeyId":     accessKey,
+		"SecretAccessKey": "fake-secret",
+		"Token":           "fake-token",
+		"Expiration":      time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+	}
+}
+
+// newFakeIMDS serves the IMDSv2 token and instance profile credential paths
+func newFakeIMDS(t *testing.T, accessKey string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+		_, _ = w.Write([]byte("fake-imds-token"))
+	})
+	mux.HandleFunc("/latest/meta-data/iam/security-credentials/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("test-role"))
+	})
+	mux.HandleFunc("/latest/meta-data/iam/security-credentials/test-role", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(fakeCredentialsBody(accessKey))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newFakeContainerEndpoint serves credentials the way the ECS agent does
+func newFakeContainerEndpoint(t *testing.T, accessKey string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(fakeCredentialsBody(accessKey))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// isolateAwsEnv keeps the developer's own AWS environment out of the tests
+func isolateAwsEnv(t *testing.T) string {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE", "AWS_CA_BUNDLE",
+		"AWS_ROLE_ARN", "AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ENDPOINT_URL", "AWS_ENDPOINT_URL_STS",
+		"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_CONTAINER_CREDENTIALS_FULL_URI",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN", "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE",
+	} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "")
+	return dir
+}
+
+func TestNewAwsCredentials(t *testing.T) {
+	imdsSrv := newFakeIMDS(t, "IMDSKEY")
+	ecsSrv := newFakeContainerEndpoint(t, "ECSKEY")
+
+	tests := []struct {
+		name    string
+		creds   AwsCredentials
+		setup   func(t *testing.T, dir string)
+		wantKey string
+		wantErr bool
+	}{
+		{
+			name:    "static",
+			creds:   AwsCredentials{Type: CredTypeStatic, AccessKeyID: "STATICKEY", SecretAccessKey: "secret"},
+			wantKey: "STATICKEY",
+		},
+		{
+			name:    "implicit static",
+			creds:   AwsCredentials{AccessKeyID: "STATICKEY", SecretAccessKey: "secret"},
+			wantKey: "STATICKEY",
+		},
+		{
+			name:    "static without keys",
+			creds:   AwsCredentials{Type: CredTypeStatic},
+			wantErr: true,
+		},
+		{
+			name:  "env",
+			creds: AwsCredentials{Type: CredTypeEnv},
+			setup: func(t *testing.T, dir string) {
+				t.Setenv("AWS_ACCESS_KEY_ID", "ENVKEY")
+				t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+			},
+			wantKey: "ENVKEY",
+		},
+		{
+			name:    "env without keys",
+			creds:   AwsCredentials{Type: CredTypeEnv},
+			wantErr: true,
+		},
+		{
+			name:  "default chain",
+			creds: AwsCredentials{Type: CredTypeDefault, Region: "us-east-1"},
+			setup: func(t *testing.T, dir string) {
+				t.Setenv("AWS_ACCESS_KEY_ID", "CHAINKEY")
+				t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+			},
+			wantKey: "CHAINKEY",
+		},
+		{
+			name:  "cli profile",
+			creds: AwsCredentials{Type: CredTypeCli, Region: "us-east-1", Profile: "laptop"},
+			setup: func(t *testing.T, dir string) {
+				data := "[laptop]\naws_access_key_id = PROFILEKEY\naws_secret_access_key = secret\n"
+				assert.Nil(t, os.WriteFile(filepath.Join(dir, "credentials"), []byte(data), 0600))
+			},
+			wantKey: "PROFILEKEY",
+		},
+		{
+			name:    "instance role",
+			creds:   AwsCredentials{Type: CredTypeIAMRole, IMDSEndpoint: imdsSrv.URL},
+			wantKey: "IMDSKEY",
+		},
+		{
+			name:    "container",
+			creds:   AwsCredentials{Type: CredTypeContainer, ContainerEndpoint: ecsSrv.URL},
+			wantKey: "ECSKEY",
+		},
+		{
+			name:    "container without endpoint",
+			creds:   AwsCredentials{Type: CredTypeContainer},
+			wantErr: true,
+		},
+		{
+			name:  "managed in a container",
+			creds: AwsCredentials{Type: CredTypeManaged, IMDSEndpoint: imdsSrv.URL},
+			setup: func(t *testing.T, dir string) {
+				t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", ecsSrv.URL)
+			},
+			wantKey: "ECSKEY",
+		},
+		{
+			name:    "managed on an instance",
+			creds:   AwsCredentials{Type: CredTypeManaged, IMDSEndpoint: imdsSrv.URL},
+			wantKey: "IMDSKEY",
+		},
+		{
+			name:    "web identity without role",
+			creds:   AwsCredentials{Type: CredTypeWebIdentity},
+			wantErr: true,
+		},
+		{
+			name:    "unknown",
+			creds:   AwsCredentials{Type: "nope"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := isolateAwsEnv(t)
+			if tt.setup != nil {
+				tt.setup(t, dir)
+			}
+
+			provider, err := NewAwsCredentials(&tt.creds)
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+
+			value, err := provider.Retrieve(context.Background())
+			assert.Nil(t, err)
+			assert.Equal(t, tt.wantKey, value.AccessKeyID)
+		})
+	}
+}
+
+type fakeStsCall struct {
+	RoleArn    string
+	SignedWith string
+	ExternalId string
+	MFAToken   string
+}
+
+// fakeSTS answers AssumeRole with credentials named after the role that was assumed, and
+// GetCallerIdentity with a user named after the signing key
+type fakeSTS struct {
+	*httptest.Server
+	calls []fakeStsCall
+}
+
+func newFakeSTS(t *testing.T) *fakeSTS {
+	stub := &fakeSTS{}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+
+		// Credential=AKID/20240101/region/sts/aws4_request
+		signedWith := ""
+		if auth := r.Header.Get("Authorization"); strings.Contains(auth, "Credential=") {
+			signedWith = strings.SplitN(strings.SplitN(auth, "Credential=", 2)[1], "/", 2)[0]
+		}
+
+		switch r.Form.Get("Action") {
+		case "AssumeRole":
+			roleArn := r.Form.Get("RoleArn")
+			stub.calls = append(stub.calls, fakeStsCall{
+				RoleArn:    roleArn,
+				SignedWith: signedWith,
+				ExternalId: r.Form.Get("ExternalId"),
+				MFAToken:   r.Form.Get("TokenCode"),
+			})
+			w.Header().Set("Content-Type", "text/xml")
+			fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>%s</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>%s/session</Arn>
+      <AssumedRoleId>AROA:session</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleResult>
+  <ResponseMetadata><RequestId>req</RequestId></ResponseMetadata>
+</AssumeRoleResponse>`, fakeRoleKey(roleArn), time.Now().Add(time.Hour).UTC().Format(time.RFC3339), roleArn)
+		case "GetCallerIdentity":
+			stub.calls = append(stub.calls, fakeStsCall{SignedWith: signedWith})
+			w.Header().Set("Content-Type", "text/xml")
+			fmt.Fprintf(w, `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetCallerIdentityResult>
+    <Arn>arn:aws:iam::123456789012:user/%s</Arn>
+    <UserId>AIDA%s</UserId>
+    <Account>123456789012</Account>
+  </GetCallerIdentityResult>
+  <ResponseMetadata><RequestId>req</RequestId></ResponseMetadata>
+</GetCallerIdentityResponse>`, signedWith, signedWith)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func fakeRoleKey(roleArn string) string {
+	return "KEY-" + roleArn[strings.LastIndex(roleArn, "/")+1:]
+}
+
+func TestAssumeRoleChain(t *testing.T) {
+	isolateAwsEnv(t)
+	stub := newFakeSTS(t)
+	t.Setenv("AWS_ENDPOINT_URL_STS", stub.URL)
+
+	creds := &AwsCredentials{
+		Type:            CredTypeAssumeRole,
+		Region:          "us-east-1",
+		SourceType:      CredTypeStatic,
+		AccessKeyID:     "BASEKEY",
+		SecretAccessKey: "secret",
+		RoleArn:         "arn:aws:iam::111111111111:role/tooling",
+		ExternalId:      "external",
+		RoleChain: []AwsRole{
+			{RoleArn: "arn:aws:iam::222222222222:role/workload", MFASerial: "arn:aws:iam::111111111111:mfa/me"},
+		},
+		MFATokenProvider: func() (string, error) { return "123456", nil },
+	}
+
+	provider, err := NewAwsCredentials(creds)
+	assert.Nil(t, err)
+
+	value, err := provider.Retrieve(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "KEY-workload", value.AccessKeyID)
+
+	// Each hop is signed by the one before it
+	assert.Equal(t, []fakeStsCall{
+		{RoleArn: "arn:aws:iam::111111111111:role/tooling", SignedWith: "BASEKEY", ExternalId: "external"},
+		{RoleArn: "arn:aws:iam::222222222222:role/workload", SignedWith: "KEY-tooling", MFAToken: "123456"},
+	}, stub.calls)
+
+	// Cached until close to expiry
+	_, err = provider.Retrieve(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(stub.calls))
+
+	// The v1 bridge hands out the same credentials
+	v1, err := NewAwsCredentialsV1(creds)
+	assert.Nil(t, err)
+	v1Value, err := v1.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, "KEY-workload", v1Value.AccessKeyID)
+}
+
+func TestAssumeRoleRequiresRole(t *testing.T) {
+	isolateAwsEnv(t)
+
+	_, err := NewAwsCredentials(&AwsCredentials{Type: CredTypeAssumeRole, SourceType: CredTypeStatic, AccessKeyID: "a", SecretAccessKey: "b"})
+	assert.NotNil(t, err)
+
+	_, err = NewAwsCredentials(&AwsCredentials{Type: CredTypeAssumeRole, SourceType: CredTypeAssumeRole, RoleArn: "arn:aws:iam::1:role/x"})
+	assert.NotNil(t, err)
+
+	// MFA needs a token provider, nothing is read from stdin
+	_, err = NewAwsCredentials(&AwsCredentials{
+		Type: CredTypeAssumeRole, SourceType: CredTypeStatic, AccessKeyID: "a", SecretAccessKey: "b",
+		RoleArn: "arn:aws:iam::1:role/x", MFASerial: "arn:aws:iam::1:mfa/me",
+	})
+	assert.True(t, errors.Is(err, cloudy.ErrInvalidConfiguration))
+}
@@ -0,0 +1,218 @@
+package cloudyaws
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/appliedres/cloudy"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	awsv1 "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	credentialsv1 "github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// NewAwsConfig builds the aws-sdk-go-v2 config every client in this package is created from.
+// Region, credential type, endpoint, retry settings and HTTP client all come from AwsCredentials.
+func NewAwsConfig(ctx context.Context, creds *AwsCredentials) (aws.Config, error) {
+	provider, err := NewAwsCredentials(creds)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	return loadAwsConfig(ctx, creds, provider)
+}
+
+// NewAwsSession builds the aws-sdk-go v1 session equivalent of NewAwsConfig for the
+// clients that have not moved to v2 yet.
+func NewAwsSession(ctx context.Context, creds *AwsCredentials) (*session.Session, error) {
+	provider, err := NewAwsCredentials(creds)
+	if err != nil {
+		return nil, err
+	}
+	return newAwsSession(creds, provider)
+}
+
+// newAwsSessionConfig builds the v1 session and the v2 config on one credentials provider, so
+// the v1 clients can validate their credentials without resolving them again
+func newAwsSessionConfig(ctx context.Context, creds *AwsCredentials) (*session.Session, aws.Config, error) {
+	cfg, err := NewAwsConfig(ctx, creds)
+	if err != nil {
+		return nil, aws.Config{}, err
+	}
+	sess, err := newAwsSession(creds, cfg.Credentials)
+	if err != nil {
+		return nil, aws.Config{}, err
+	}
+	return sess, cfg, nil
+}
+
+// newAwsSession applies the AwsCredentials settings around an already resolved provider
+func newAwsSession(creds *AwsCredentials, provider aws.CredentialsProvider) (*session.Session, error) {
+	region, _, err := creds.ResolveRegion()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := awsv1.Config{
+		Credentials:      credentialsv1.NewCredentials(&v1CredentialsAdapter{provider: provider}),
+		Region:           awsv1.String(region),
+		EndpointResolver: endpointResolverV1(creds),
+	}
+	if creds.UseFIPS {
+		cfg.UseFIPSEndpoint = endpoints.FIPSEndpointStateEnabled
+	}
+	if creds.UseDualStack {
+		cfg.UseDualStackEndpoint = endpoints.DualStackEndpointStateEnabled
+	}
+	if creds.S3ForcePathStyle {
+		cfg.S3ForcePathStyle = awsv1.Bool(true)
+	}
+	if creds.MaxAttempts > 0 {
+		cfg.MaxRetries = awsv1.Int(creds.MaxAttempts - 1)
+	}
+	if creds.RetryMode != "" {
+		// v1 only has the standard retryer, adaptive rate limiting is v2 only
+		if !strings.EqualFold(creds.RetryMode, string(aws.RetryModeStandard)) {
+			return nil, fmt.Errorf("retry mode %v is not supported by aws-sdk-go v1 clients: %w", creds.RetryMode, cloudy.ErrInvalidConfiguration)
+		}
+		retryer := client.DefaultRetryer{NumMaxRetries: client.DefaultRetryerMaxNumRetries}
+		if creds.MaxAttempts > 0 {
+			retryer.NumMaxRetries = creds.MaxAttempts - 1
+		}
+		cfg.Retryer = retryer
+	}
+	if creds.HTTPClient != nil {
+		cfg.HTTPClient = creds.HTTPClient
+	}
+
+	return session.NewSessionWithOptions(session.Options{
+		Config:            cfg,
+		Profile:           creds.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+}
+
+// loadAwsConfig applies the AwsCredentials settings around an already resolved provider.
+// It is also used for the STS clients that resolve role credentials.
+func loadAwsConfig(ctx context.Context, creds *AwsCredentials, provider aws.CredentialsProvider) (aws.Config, error) {
+	region, _, err := creds.ResolveRegion()
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	opts := []func(*config.LoadOptions) error{
+		config.WithRegion(region),
+		config.WithCredentialsProvider(provider),
+	}
+	if creds.UseFIPS {
+		opts = append(opts, config.WithUseFIPSEndpoint(aws.FIPSEndpointStateEnabled))
+	}
+	if creds.UseDualStack {
+		opts = append(opts, config.WithUseDualStackEndpoint(aws.DualStackEndpointStateEnabled))
+	}
+	if creds.Profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(creds.Profile))
+	}
+	if creds.Endpoint != "" {
+		opts = append(opts, config.WithBaseEndpoint(creds.Endpoint))
+	}
+	if creds.RetryMode != "" {
+		mode, err := aws.ParseRetryMode(strings.ToLower(creds.RetryMode))
+		if err != nil {
+			return aws.Config{}, err
+		}
+		opts = append(opts, config.WithRetryMode(mode))
+	}
+	if creds.MaxAttempts > 0 {
+		opts = append(opts, config.WithRetryMaxAttempts(creds.MaxAttempts))
+	}
+	if creds.HTTPClient != nil {
+		opts = append(opts, config.WithHTTPClient(creds.HTTPClient))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return cfg, nil
+}
+
+// EndpointFor returns the endpoint override for a service, or "" to use the AWS default.
+// Service names are matched loosely so the v1 endpoint ID ("secretsmanager") and the v2
+// service ID ("Secrets Manager") find the same entry. The settings of the credentials come
+// first, the Endpoints map and then Endpoint, followed by AWS_ENDPOINT_URL_<SERVICE> and
+// AWS_ENDPOINT_URL.
+func (creds *AwsCredentials) EndpointFor(service string) string {
+	key := normalizeServiceName(service)
+	for name, url := range creds.Endpoints {
+		if normalizeServiceName(name) == key && url != "" {
+			return url
+		}
+	}
+	if creds.Endpoint != "" {
+		return creds.Endpoint
+	}
+	if url := os.Getenv(serviceEndpointEnv(key)); url != "" {
+		return url
+	}
+	return os.Getenv(EndpointEnv)
+}
+
+// EndpointEnv is the global endpoint override, AWS_ENDPOINT_URL_<SERVICE> overrides it per service
+const EndpointEnv = "AWS_ENDPOINT_URL"
+
+// Services whose environment variable suffix is not just the upper cased name
+var serviceEndpointEnvNames = map[string]string{
+	"secretsmanager": "SECRETS_MANAGER",
+	"servicequotas":  "SERVICE_QUOTAS",
+	"route53":        "ROUTE_53",
+}
+
+// EndpointServices are the services read from the environment by readEndpointsFromEnv
+var EndpointServices = []string{
+	"cloudfront", "dynamodb", "ec2", "iam", "route53", "s3", "secretsmanager",
+	"servicequotas", "sqs", "ssm", "sts",
+}
+
+func normalizeServiceName(service string) string {
+	r := strings.NewReplacer(" ", "", "-", "", "_", "")
+	return strings.ToLower(r.Replace(service))
+}
+
+func serviceEndpointEnv(service string) string {
+	key := normalizeServiceName(service)
+	if name, ok := serviceEndpointEnvNames[key]; ok {
+		return EndpointEnv + "_" + name
+	}
+	return EndpointEnv + "_" + strings.ToUpper(key)
+}
+
+// readEndpointsFromEnv collects the global and per service endpoint overrides
+func readEndpointsFromEnv(env *cloudy.Environment, prefix string) (string, map[string]string) {
+	reader := envReader{env: env, prefix: prefix}
+	endpoints := make(map[string]string)
+	for _, service := range EndpointServices {
+		if url := reader.get(serviceEndpointEnv(service)); url != "" {
+			endpoints[service] = url
+		}
+	}
+	return reader.get(EndpointEnv), endpoints
+}
+
+// endpointResolverV1 gives the v1 clients the same endpoint lookup the v2 clients get
+func endpointResolverV1(creds *AwsCredentials) endpoints.Resolver {
+	return endpoints.ResolverFunc(func(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		if url := creds.EndpointFor(service); url != "" {
+			return endpoints.ResolvedEndpoint{
+				URL:           url,
+				SigningRegion: region,
+			}, nil
+		}
+		return endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+	})
+}
@@ -0,0 +1,122 @@
+package cloudyaws
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/appliedres/cloudy"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go/aws/client"
+	stsv1 "github.com/aws/aws-sdk-go/service/sts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAwsConfig(t *testing.T) {
+	isolateAwsEnv(t)
+	stub := newFakeSTS(t)
+
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+	creds := &AwsCredentials{
+		Region:          "us-east-1",
+		AccessKeyID:     "CONFIGKEY",
+		SecretAccessKey: "secret",
+		Endpoint:        stub.URL,
+		RetryMode:       "adaptive",
+		MaxAttempts:     2,
+		HTTPClient:      httpClient,
+	}
+
+	cfg, err := NewAwsConfig(context.Background(), creds)
+	assert.Nil(t, err)
+	assert.Equal(t, "us-east-1", cfg.Region)
+	assert.Equal(t, aws.RetryModeAdaptive, cfg.RetryMode)
+	assert.Equal(t, 2, cfg.RetryMaxAttempts)
+	assert.Equal(t, httpClient, cfg.HTTPClient)
+
+	// v2 and v1 clients built from the same credentials reach the same endpoint
+	out, err := sts.NewFromConfig(cfg).GetCallerIdentity(context.Background(), &sts.GetCallerIdentityInput{})
+	assert.Nil(t, err)
+	assert.Equal(t, "arn:aws:iam::123456789012:user/CONFIGKEY", aws.ToString(out.Arn))
+
+	// v1 clients have no adaptive retry mode, v2 clients such as Dynamo do
+	_, err = NewAwsSession(context.Background(), creds)
+	assert.True(t, errors.Is(err, cloudy.ErrInvalidConfiguration))
+	d, err := NewDynamo[testDocument](context.Background(), creds, "documents")
+	assert.Nil(t, err)
+	assert.Equal(t, aws.RetryModeAdaptive, d.Client.Options().RetryMode)
+	assert.Equal(t, 2, d.Client.Options().RetryMaxAttempts)
+
+	creds.RetryMode = "standard"
+	sess, err := NewAwsSession(context.Background(), creds)
+	assert.Nil(t, err)
+	assert.Equal(t, "us-east-1", aws.ToString(sess.Config.Region))
+	assert.Equal(t, 1, aws.ToInt(sess.Config.MaxRetries))
+	assert.Equal(t, client.DefaultRetryer{NumMaxRetries: 1}, sess.Config.Retryer)
+
+	outV1, err := stsv1.New(sess).GetCallerIdentity(&stsv1.GetCallerIdentityInput{})
+	assert.Nil(t, err)
+	assert.Equal(t, "arn:aws:iam::123456789012:user/CONFIGKEY", aws.ToString(outV1.Arn))
+	assert.Equal(t, 2, len(stub.calls))
+
+	// Region falls back to the package default
+	cfg, err = NewAwsConfig(context.Background(), &AwsCredentials{AccessKeyID: "a", SecretAccessKey: "b"})
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultRegion, cfg.Region)
+
+	_, err = NewAwsConfig(context.Background(), &AwsCredentials{AccessKeyID: "a", SecretAccessKey: "b", RetryMode: "sometimes"})
+	assert.NotNil(t, err)
+}
+
+func TestEndpointOverrides(t *testing.T) {
+	isolateAwsEnv(t)
+	stub := newFakeSTS(t)
+
+	creds := &AwsCredentials{
+		Region:          "us-east-1",
+		AccessKeyID:     "ENDPOINTKEY",
+		SecretAccessKey: "secret",
+		Endpoint:        "http://127.0.0.1:1",
+		Endpoints:       map[string]string{"sts": stub.URL},
+	}
+
+	// The per service entry wins over the global endpoint, for either spelling of the service
+	assert.Equal(t, stub.URL, creds.EndpointFor("sts"))
+	assert.Equal(t, stub.URL, creds.EndpointFor(sts.ServiceID))
+	assert.Equal(t, "http://127.0.0.1:1", creds.EndpointFor("secretsmanager"))
+
+	// The environment does not override the endpoints of the credentials
+	t.Setenv("AWS_ENDPOINT_URL_SECRETS_MANAGER", "http://localhost:4566")
+	t.Setenv("AWS_ENDPOINT_URL_STS", "http://localhost:4566")
+	assert.Equal(t, "http://127.0.0.1:1", creds.EndpointFor("Secrets Manager"))
+	assert.Equal(t, stub.URL, creds.EndpointFor("sts"))
+	assert.Equal(t, "http://localhost:4566", (&AwsCredentials{}).EndpointFor("Secrets Manager"))
+
+	sess, err := NewAwsSession(context.Background(), creds)
+	assert.Nil(t, err)
+	out, err := stsv1.New(sess).GetCallerIdentity(&stsv1.GetCallerIdentityInput{})
+	assert.Nil(t, err)
+	assert.Equal(t, "123456789012", aws.ToString(out.Account))
+
+	// Nothing configured leaves the AWS defaults alone
+	assert.Equal(t, "", (&AwsCredentials{}).EndpointFor("dynamodb"))
+	t.Setenv("AWS_ENDPOINT_URL", "http://localhost:4566")
+	assert.Equal(t, "http://localhost:4566", (&AwsCredentials{}).EndpointFor("dynamodb"))
+}
+
+func TestReadEndpointsFromEnv(t *testing.T) {
+	envSvc := cloudy.NewMapEnvironment()
+	envSvc.Set("AWS_ENDPOINT_URL", "http://localstack:4566")
+	envSvc.Set("AWS_ENDPOINT_URL_DYNAMODB", "http://dynamodb-local:8000")
+	envSvc.Set("AWS_ENDPOINT_URL_SECRETS_MANAGER", "http://moto:5000")
+
+	endpoint, endpoints := readEndpointsFromEnv(cloudy.NewEnvironment(envSvc), "")
+	assert.Equal(t, "http://localstack:4566", endpoint)
+	assert.Equal(t, map[string]string{
+		"dynamodb":       "http://dynamodb-local:8000",
+		"secretsmanager": "http://moto:5000",
+	}, endpoints)
+}
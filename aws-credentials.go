@@ -1,7 +1,10 @@
 package cloudyaws
 
 import (
+	"context"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/appliedres/cloudy"
 )
@@ -12,41 +15,291 @@ func init() {
 
 const AwsCredentialsKey = "aws"
 
-type AwsCredentialLoader struct{}
+// Environment variables read into AwsCredentials by AwsCredentialsFromEnv. With a prefix
+// every name is joined to it, so "BACKUP" reads BACKUP_AWS_ACCESS_KEY_ID and so on.
+//
+//	AWS_CRED_TYPE                 Type, inferred from the other values when empty
+//	AWS_REGION                    Region, falls back to AWS_DEFAULT_REGION then DefaultRegion
+//	AWS_PARTITION                 Partition
+//	AWS_LOCATION                  Location
+//	AWS_ACCESS_KEY_ID             AccessKeyID, required for the secret type
+//	AWS_SECRET_ACCESS_KEY         SecretAccessKey, required for the secret type
+//	AWS_SESSION_TOKEN             SessionToken
+//	AWS_PROFILE                   Profile
+//	AWS_ROLE_ARN                  RoleArn, required for the assumerole and webidentity types
+//	AWS_ROLE_SESSION_NAME         SessionName
+//	AWS_ROLE_EXTERNAL_ID          ExternalId
+//	AWS_ROLE_DURATION_SECONDS     DurationSeconds
+//	AWS_ROLE_SOURCE_CRED_TYPE     SourceType
+//	AWS_MFA_SERIAL                MFASerial
+//	AWS_WEB_IDENTITY_TOKEN_FILE   WebIdentityTokenFile, required for the webidentity type
+//	AWS_ENDPOINT_URL[_<SERVICE>]  Endpoint and Endpoints
+//	AWS_S3_FORCE_PATH_STYLE       S3ForcePathStyle
+//	AWS_USE_FIPS_ENDPOINT         UseFIPS
+//	AWS_USE_DUALSTACK_ENDPOINT    UseDualStack
+//	AWS_RETRY_MODE                RetryMode
+//	AWS_MAX_ATTEMPTS              MaxAttempts
+//	AWS_VALIDATE_IDENTITY         ValidateIdentity
+//	AWS_VALIDATE_PERMISSIONS      ValidatePermissions
+const (
+	EnvCredType             = "AWS_CRED_TYPE"
+	EnvRegion               = "AWS_REGION"
+	EnvDefaultRegion        = "AWS_DEFAULT_REGION"
+	EnvPartition            = "AWS_PARTITION"
+	EnvLocation             = "AWS_LOCATION"
+	EnvAccessKeyID          = "AWS_ACCESS_KEY_ID"
+	EnvSecretAccessKey      = "AWS_SECRET_ACCESS_KEY"
+	EnvSessionToken         = "AWS_SESSION_TOKEN"
+	EnvProfile              = "AWS_PROFILE"
+	EnvRoleArn              = "AWS_ROLE_ARN"
+	EnvRoleSessionName      = "AWS_ROLE_SESSION_NAME"
+	EnvRoleExternalId       = "AWS_ROLE_EXTERNAL_ID"
+	EnvRoleDurationSeconds  = "AWS_ROLE_DURATION_SECONDS"
+	EnvRoleSourceType       = "AWS_ROLE_SOURCE_CRED_TYPE"
+	EnvMFASerial            = "AWS_MFA_SERIAL"
+	EnvWebIdentityTokenFile = "AWS_WEB_IDENTITY_TOKEN_FILE"
+	EnvS3ForcePathStyle     = "AWS_S3_FORCE_PATH_STYLE"
+	EnvUseFIPS              = "AWS_USE_FIPS_ENDPOINT"
+	EnvUseDualStack         = "AWS_USE_DUALSTACK_ENDPOINT"
+	EnvRetryMode            = "AWS_RETRY_MODE"
+	EnvMaxAttempts          = "AWS_MAX_ATTEMPTS"
+	EnvValidateIdentity     = "AWS_VALIDATE_IDENTITY"
+	EnvValidatePermissions  = "AWS_VALIDATE_PERMISSIONS"
+)
+
+// Older names still accepted when the current one is not set
+var legacyEnvNames = map[string]string{
+	EnvAccessKeyID:     "AWS_ACCESS_KEY",
+	EnvSecretAccessKey: "AWS_SECRET_KEY",
+}
+
+// MissingEnvError is returned when a variable the credential type needs is not set
+type MissingEnvError struct {
+	Name     string
+	CredType string
+}
+
+func (e *MissingEnvError) Error() string {
+	return fmt.Sprintf("environment variable %v is required for %v credentials", e.Name, e.CredType)
+}
+
+func (e *MissingEnvError) Is(target error) bool {
+	return target == cloudy.ErrInvalidConfiguration
+}
+
+// InvalidEnvError is returned when a variable cannot be parsed
+type InvalidEnvError struct {
+	Name  string
+	Value string
+	Err   error
+}
+
+func (e *InvalidEnvError) Error() string {
+	return fmt.Sprintf("environment variable %v has an invalid value %q: %v", e.Name, e.Value, e.Err)
+}
+
+func (e *InvalidEnvError) Unwrap() error {
+	return e.Err
+}
 
+func (e *InvalidEnvError) Is(target error) bool {
+	return target == cloudy.ErrInvalidConfiguration
+}
+
+// AwsCredentialLoader reads the AWS credentials for cloudy.CredentialSources. Set Prefix to
+// read a namespaced set of variables.
+type AwsCredentialLoader struct {
+	Prefix string
+}
+
+// ReadFromEnv returns the AwsCredentials, nil when no AWS variables are set, or the
+// *MissingEnvError or *InvalidEnvError of a misconfigured environment
 func (loader *AwsCredentialLoader) ReadFromEnv(env *cloudy.Environment) interface{} {
 	fmt.Println("AWS Credentials: ReadFromEnv")
-	region := env.Get("AWS_REGION")
-	if region == "" {
-		region = "us-gov-west-1"
-	}
-	accessKeyId := env.Get("AWS_ACCESS_KEY_ID")
-	secretAccessKey := env.Get("AWS_SECRET_ACCESS_KEY")
 
-	if accessKeyId == "" || secretAccessKey == "" {
+	reader := envReader{env: env, prefix: loader.Prefix}
+	if reader.get(EnvAccessKeyID) == "" && reader.get(EnvCredType) == "" &&
+		reader.get(EnvRoleArn) == "" && reader.get(EnvProfile) == "" {
 		return nil
 	}
 
-	return AwsCredentials{
-		Region:       		region,
-		AccessKeyID:    	accessKeyId,
-		SecretAccessKey:    secretAccessKey,
+	// A misconfigured environment is kept as the error, for GetAwsCredentialsFromEnv to return
+	creds, err := AwsCredentialsFromEnv(env, loader.Prefix)
+	if err != nil {
+		cloudy.Warn(context.Background(), "AWS Credentials: %v", err)
+		return err
 	}
-
+	return creds
 }
 
-func GetAwsCredentialsFromEnv(env *cloudy.Environment) AwsCredentials {
+// GetAwsCredentialsFromEnv returns the credentials already loaded into the environment, or the
+// error loading them, or reads them from the unprefixed variables.
+func GetAwsCredentialsFromEnv(env *cloudy.Environment) (AwsCredentials, error) {
 	fmt.Println("AWS Credentials: GetAwsCredentialsFromEnv")
 
 	// Check to see if there is already a set of credentials
-	creds := env.GetCredential(AwsCredentialsKey)
-	if creds != nil {
-		return creds.(AwsCredentials)
+	switch creds := env.GetCredential(AwsCredentialsKey).(type) {
+	case AwsCredentials:
+		return creds, nil
+	case *AwsCredentials:
+		return *creds, nil
+	case error:
+		return AwsCredentials{}, creds
+	}
+
+	return AwsCredentialsFromEnv(env, "")
+}
+
+// AwsCredentialsFromEnv maps the variables listed above into AwsCredentials. Missing required
+// values are reported as *MissingEnvError and unparsable ones as *InvalidEnvError.
+func AwsCredentialsFromEnv(env *cloudy.Environment, prefix string) (AwsCredentials, error) {
+	reader := envReader{env: env, prefix: prefix}
+
+	creds := AwsCredentials{
+		Type:                 reader.get(EnvCredType),
+		Region:               reader.get(EnvRegion),
+		Partition:            reader.get(EnvPartition),
+		Location:             reader.get(EnvLocation),
+		AccessKeyID:          reader.get(EnvAccessKeyID),
+		SecretAccessKey:      reader.get(EnvSecretAccessKey),
+		SessionToken:         reader.get(EnvSessionToken),
+		Profile:              reader.get(EnvProfile),
+		RoleArn:              reader.get(EnvRoleArn),
+		SessionName:          reader.get(EnvRoleSessionName),
+		ExternalId:           reader.get(EnvRoleExternalId),
+		SourceType:           reader.get(EnvRoleSourceType),
+		MFASerial:            reader.get(EnvMFASerial),
+		WebIdentityTokenFile: reader.get(EnvWebIdentityTokenFile),
+		RetryMode:            reader.get(EnvRetryMode),
+	}
+	if creds.Region == "" {
+		creds.Region = reader.get(EnvDefaultRegion)
+	}
+	if creds.Region == "" {
+		creds.Region = DefaultRegion
+	}
+	creds.Endpoint, creds.Endpoints = readEndpointsFromEnv(env, prefix)
+
+	for name, field := range map[string]*bool{
+		EnvS3ForcePathStyle:    &creds.S3ForcePathStyle,
+		EnvUseFIPS:             &creds.UseFIPS,
+		EnvUseDualStack:        &creds.UseDualStack,
+		EnvValidateIdentity:    &creds.ValidateIdentity,
+		EnvValidatePermissions: &creds.ValidatePermissions,
+	} {
+		if err := reader.bool(name, field); err != nil {
+			return AwsCredentials{}, err
+		}
+	}
+	if err := reader.int(EnvMaxAttempts, &creds.MaxAttempts); err != nil {
+		return AwsCredentials{}, err
+	}
+	var duration int
+	if err := reader.int(EnvRoleDurationSeconds, &duration); err != nil {
+		return AwsCredentials{}, err
+	}
+	creds.DurationSeconds = int32(duration)
+
+	if creds.Type == "" {
+		creds.Type = inferCredType(&creds)
+	}
+	if err := reader.checkRequired(&creds); err != nil {
+		return AwsCredentials{}, err
+	}
+	return creds, nil
+}
+
+// inferCredType picks the credential type from the values that are set
+func inferCredType(creds *AwsCredentials) string {
+	switch {
+	case creds.RoleArn != "" && creds.WebIdentityTokenFile != "":
+		return CredTypeWebIdentity
+	case creds.RoleArn != "":
+		if creds.SourceType == "" && creds.AccessKeyID != "" {
+			creds.SourceType = CredTypeSecret
+		}
+		return CredTypeAssumeRole
+	case creds.AccessKeyID != "":
+		return CredTypeSecret
+	default:
+		return CredTypeDefault
+	}
+}
+
+// envReader reads variables under an optional prefix
+type envReader struct {
+	env    *cloudy.Environment
+	prefix string
+}
+
+func (r envReader) name(name string) string {
+	if r.prefix == "" {
+		return name
+	}
+	return cloudy.EnvJoin(r.prefix, name)
+}
+
+func (r envReader) get(name string) string {
+	if v := r.env.Get(r.name(name)); v != "" {
+		return v
+	}
+	if legacy, ok := legacyEnvNames[name]; ok {
+		return r.env.Get(r.name(legacy))
+	}
+	return ""
+}
+
+func (r envReader) bool(name string, field *bool) error {
+	v := r.get(name)
+	if v == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return &InvalidEnvError{Name: r.name(name), Value: v, Err: err}
+	}
+	*field = b
+	return nil
+}
+
+func (r envReader) int(name string, field *int) error {
+	v := r.get(name)
+	if v == "" {
+		return nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return &InvalidEnvError{Name: r.name(name), Value: v, Err: err}
+	}
+	*field = i
+	return nil
+}
+
+func (r envReader) checkRequired(creds *AwsCredentials) error {
+	var required []string
+	switch strings.ToLower(creds.Type) {
+	case CredTypeSecret, CredTypeStatic:
+		if creds.AccessKeyID == "" {
+			required = append(required, EnvAccessKeyID)
+		}
+		if creds.SecretAccessKey == "" {
+			required = append(required, EnvSecretAccessKey)
+		}
+	case CredTypeAssumeRole:
+		if creds.RoleArn == "" {
+			required = append(required, EnvRoleArn)
+		}
+	case CredTypeWebIdentity:
+		if creds.RoleArn == "" {
+			required = append(required, EnvRoleArn)
+		}
+		if creds.WebIdentityTokenFile == "" {
+			required = append(required, EnvWebIdentityTokenFile)
+		}
 	}
 
-	return AwsCredentials{
-		Region:       env.Force("AWS_REGION"),
-		AccessKeyID:     env.Force("AWS_ACCESS_KEY_ID"),
-		SecretAccessKey:     env.Force("AWS_SECRET_ACCESS_KEY"),
+	if len(required) > 0 {
+		return &MissingEnvError{Name: r.name(required[0]), CredType: creds.Type}
 	}
+	return nil
 }
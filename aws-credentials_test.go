@@ -0,0 +1,154 @@
+package cloudyaws
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/appliedres/cloudy"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestEnv(vars map[string]string) *cloudy.Environment {
+	envSvc := cloudy.NewMapEnvironment()
+	for k, v := range vars {
+		envSvc.Set(k, v)
+	}
+	return cloudy.NewEnvironment(envSvc)
+}
+
+func TestAwsCredentialsFromEnv(t *testing.T) {
+	creds, err := AwsCredentialsFromEnv(newTestEnv(map[string]string{
+		"AWS_ACCESS_KEY_ID":          "AKID",
+		"AWS_SECRET_ACCESS_KEY":      "secret",
+		"AWS_SESSION_TOKEN":          "token",
+		"AWS_REGION":                 "us-east-1",
+		"AWS_PROFILE":                "dev",
+		"AWS_ENDPOINT_URL":           "http://localstack:4566",
+		"AWS_USE_FIPS_ENDPOINT":      "true",
+		"AWS_MAX_ATTEMPTS":           "5",
+		"AWS_ROLE_DURATION_SECONDS":  "900",
+		"AWS_VALIDATE_IDENTITY":      "1",
+		"AWS_S3_FORCE_PATH_STYLE":    "TRUE",
+		"AWS_USE_DUALSTACK_ENDPOINT": "false",
+	}), "")
+	assert.Nil(t, err)
+	assert.Equal(t, CredTypeSecret, creds.Type)
+	assert.Equal(t, "AKID", creds.AccessKeyID)
+	assert.Equal(t, "secret", creds.SecretAccessKey)
+	assert.Equal(t, "token", creds.SessionToken)
+	assert.Equal(t, "us-east-1", creds.Region)
+	assert.Equal(t, "dev", creds.Profile)
+	assert.Equal(t, "http://localstack:4566", creds.Endpoint)
+	assert.True(t, creds.UseFIPS)
+	assert.False(t, creds.UseDualStack)
+	assert.True(t, creds.S3ForcePathStyle)
+	assert.True(t, creds.ValidateIdentity)
+	assert.Equal(t, 5, creds.MaxAttempts)
+	assert.Equal(t, int32(900), creds.DurationSeconds)
+
+	// Nothing set falls back to the default chain in the default region
+	creds, err = AwsCredentialsFromEnv(newTestEnv(nil), "")
+	assert.Nil(t, err)
+	assert.Equal(t, CredTypeDefault, creds.Type)
+	assert.Equal(t, DefaultRegion, creds.Region)
+
+	// Legacy names
+	creds, err = AwsCredentialsFromEnv(newTestEnv(map[string]string{
+		"AWS_ACCESS_KEY": "OLD",
+		"AWS_SECRET_KEY": "old-secret",
+		"AWS_LOCATION":   "east",
+	}), "")
+	assert.Nil(t, err)
+	assert.Equal(t, "OLD", creds.AccessKeyID)
+	assert.Equal(t, "old-secret", creds.SecretAccessKey)
+	assert.Equal(t, "east", creds.Location)
+
+	// Keys with a role assume it using the keys
+	creds, err = AwsCredentialsFromEnv(newTestEnv(map[string]string{
+		"AWS_ACCESS_KEY_ID":     "AKID",
+		"AWS_SECRET_ACCESS_KEY": "secret",
+		"AWS_ROLE_ARN":          "arn:aws:iam::123456789012:role/deploy",
+	}), "")
+	assert.Nil(t, err)
+	assert.Equal(t, CredTypeAssumeRole, creds.Type)
+	assert.Equal(t, CredTypeSecret, creds.SourceType)
+}
+
+func TestAwsCredentialsFromEnvPrefix(t *testing.T) {
+	env := newTestEnv(map[string]string{
+		"AWS_ACCESS_KEY_ID":                       "PRIMARY",
+		"AWS_SECRET_ACCESS_KEY":                   "primary-secret",
+		"BACKUP_AWS_ACCESS_KEY_ID":                "BACKUP",
+		"BACKUP_AWS_SECRET_ACCESS_KEY":            "backup-secret",
+		"BACKUP_AWS_REGION":                       "us-gov-west-1",
+		"BACKUP_AWS_ENDPOINT_URL_DYNAMODB":        "http://dynamodb-local:8000",
+		"BACKUP_AWS_ENDPOINT_URL_SECRETS_MANAGER": "http://moto:5000",
+	})
+
+	primary, err := AwsCredentialsFromEnv(env, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "PRIMARY", primary.AccessKeyID)
+	assert.Empty(t, primary.Endpoints)
+
+	backup, err := AwsCredentialsFromEnv(env, "backup")
+	assert.Nil(t, err)
+	assert.Equal(t, "BACKUP", backup.AccessKeyID)
+	assert.Equal(t, "backup-secret", backup.SecretAccessKey)
+	assert.Equal(t, "us-gov-west-1", backup.Region)
+	assert.Equal(t, map[string]string{
+		"dynamodb":       "http://dynamodb-local:8000",
+		"secretsmanager": "http://moto:5000",
+	}, backup.Endpoints)
+
+	loaded := (&AwsCredentialLoader{Prefix: "BACKUP"}).ReadFromEnv(env)
+	assert.Equal(t, backup, loaded)
+	assert.Nil(t, (&AwsCredentialLoader{Prefix: "OTHER"}).ReadFromEnv(env))
+
+	// A misconfigured environment is not mistaken for an absent one
+	env = newTestEnv(map[string]string{"AWS_ACCESS_KEY_ID": "AKID"})
+	env.Credentials.Put(AwsCredentialsKey, (&AwsCredentialLoader{}).ReadFromEnv(env))
+	_, err = GetAwsCredentialsFromEnv(env)
+	var missing *MissingEnvError
+	assert.True(t, errors.As(err, &missing))
+	assert.Equal(t, "AWS_SECRET_ACCESS_KEY", missing.Name)
+}
+
+func TestAwsCredentialsFromEnvErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		vars    map[string]string
+		missing string
+		invalid string
+	}{
+		{"secret without key", map[string]string{"AWS_CRED_TYPE": "secret", "AWS_SECRET_ACCESS_KEY": "s"}, "AWS_ACCESS_KEY_ID", ""},
+		{"secret without secret", map[string]string{"AWS_ACCESS_KEY_ID": "AKID"}, "AWS_SECRET_ACCESS_KEY", ""},
+		{"assume role without role", map[string]string{"AWS_CRED_TYPE": "assumerole"}, "AWS_ROLE_ARN", ""},
+		{"assume role in any case", map[string]string{"AWS_CRED_TYPE": "AssumeRole"}, "AWS_ROLE_ARN", ""},
+		{"web identity in any case", map[string]string{"AWS_CRED_TYPE": "WebIdentity", "AWS_ROLE_ARN": "arn"}, "AWS_WEB_IDENTITY_TOKEN_FILE", ""},
+		{"web identity without token", map[string]string{"AWS_CRED_TYPE": "webidentity", "AWS_ROLE_ARN": "arn"}, "AWS_WEB_IDENTITY_TOKEN_FILE", ""},
+		{"bad bool", map[string]string{"AWS_USE_FIPS_ENDPOINT": "yes please"}, "", "AWS_USE_FIPS_ENDPOINT"},
+		{"bad int", map[string]string{"AWS_MAX_ATTEMPTS": "many"}, "", "AWS_MAX_ATTEMPTS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := AwsCredentialsFromEnv(newTestEnv(tt.vars), "")
+			assert.True(t, errors.Is(err, cloudy.ErrInvalidConfiguration))
+
+			var missing *MissingEnvError
+			var invalid *InvalidEnvError
+			if tt.missing != "" {
+				assert.True(t, errors.As(err, &missing))
+				assert.Equal(t, tt.missing, missing.Name)
+			} else {
+				assert.True(t, errors.As(err, &invalid))
+				assert.Equal(t, tt.invalid, invalid.Name)
+			}
+		})
+	}
+
+	_, err := AwsCredentialsFromEnv(newTestEnv(map[string]string{"CI_AWS_CRED_TYPE": "secret"}), "ci")
+	var missing *MissingEnvError
+	assert.True(t, errors.As(err, &missing))
+	assert.Equal(t, "CI_AWS_ACCESS_KEY_ID", missing.Name)
+}
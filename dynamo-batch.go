@@ -0,0 +1,197 @@
+package cloudyaws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Most items BatchWriteItem and BatchGetItem take in one call
+const (
+	dynamoBatchWriteSize = 25
+	dynamoBatchGetSize   = 100
+)
+
+// DefaultDynamoBatchRetries is how many times a batch call is repeated for the items
+// DynamoDB left unprocessed, usually because of throttling
+const DefaultDynamoBatchRetries = 8
+
+// BatchSave creates or replaces the items, 25 a call. Items are written independently, there
+// are no conditions and no optimistic locking. When items repeat a key the last one is saved.
+func (d *Dynamo[T]) BatchSave(ctx context.Context, items []*T) error {
+	if d.OptimisticLocking {
+		return errors.New("batch saves cannot check versions, use Save with optimistic locking")
+	}
+	names, err := d.keyNames(ctx)
+	if err != nil {
+		return err
+	}
+
+	requests := make([]types.WriteRequest, 0, len(items))
+	seen := map[string]int{}
+	for _, item := range items {
+		itemMap, err := attributevalue.MarshalMap(item)
+		if err != nil {
+			return err
+		}
+		keyAttrs := map[string]types.AttributeValue{}
+		for _, name := range names {
+			keyAttrs[name] = itemMap[name]
+		}
+		id, err := attributesId(keyAttrs)
+		if err != nil {
+			return err
+		}
+
+		request := types.WriteRequest{PutRequest: &types.PutRequest{Item: itemMap}}
+		if i, ok := seen[id]; ok {
+			requests[i] = request
+			continue
+		}
+		seen[id] = len(requests)
+		requests = append(requests, request)
+	}
+	return d.batchWrite(ctx, requests)
+}
+
+// BatchDelete removes the items with the keys, 25 a call. Keys that do not exist are ignored,
+// repeated keys are deleted once.
+func (d *Dynamo[T]) BatchDelete(ctx context.Context, keys []Key) error {
+	keyAttrs, err := uniqueKeyAttributes(keys)
+	if err != nil {
+		return err
+	}
+	requests := make([]types.WriteRequest, 0, len(keyAttrs))
+	for _, attrs := range keyAttrs {
+		requests = append(requests, types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: attrs}})
+	}
+	return d.batchWrite(ctx, requests)
+}
+
+// BatchGet reads the items with the keys, 100 a call, in no particular order. Keys that do
+// not exist are left out, repeated keys are read once.
+func (d *Dynamo[T]) BatchGet(ctx context.Context, keys []Key) ([]T, error) {
+	keyAttrs, err := uniqueKeyAttributes(keys)
+	if err != nil {
+		return nil, err
+	}
+	var items []T
+	for start := 0; start < len(keyAttrs); start += dynamoBatchGetSize {
+		batch := keyAttrs[start:min(start+dynamoBatchGetSize, len(keyAttrs))]
+
+		request := map[string]types.KeysAndAttributes{d.Table: {Keys: batch}}
+		for n := 0; len(request) > 0; n++ {
+			if n > DefaultDynamoBatchRetries {
+				return nil, unprocessedError("BatchGetItem", d.Table, len(request[d.Table].Keys))
+			}
+			if n > 0 {
+				if err := expBackoffContext(ctx, n, 8000); err != nil {
+					return nil, err
+				}
+			}
+
+			result, err := d.Client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: request})
+			if err != nil {
+				return nil, mapDynamoError(ctx, "BatchGetItem", d.Table, "", err)
+			}
+			var page []T
+			if err := attributevalue.UnmarshalListOfMaps(result.Responses[d.Table], &page); err != nil {
+				return nil, err
+			}
+			items = append(items, page...)
+			request = result.UnprocessedKeys
+		}
+	}
+	return items, nil
+}
+
+// batchWrite sends the requests 25 a call, repeating the ones left unprocessed
+func (d *Dynamo[T]) batchWrite(ctx context.Context, requests []types.WriteRequest) error {
+	for start := 0; start < len(requests); start += dynamoBatchWriteSize {
+		batch := requests[start:min(start+dynamoBatchWriteSize, len(requests))]
+
+		request := map[string][]types.WriteRequest{d.Table: batch}
+		for n := 0; len(request) > 0; n++ {
+			if n > DefaultDynamoBatchRetries {
+				return unprocessedError("BatchWriteItem", d.Table, len(request[d.Table]))
+			}
+			if n > 0 {
+				if err := expBackoffContext(ctx, n, 8000); err != nil {
+					return err
+				}
+			}
+
+			result, err := d.Client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: request})
+			if err != nil {
+				return mapDynamoError(ctx, "BatchWriteItem", d.Table, "", err)
+			}
+			request = result.UnprocessedItems
+		}
+	}
+	return nil
+}
+
+// unprocessedError is the error for items still unprocessed when the retries ran out
+func unprocessedError(op string, table string, count int) error {
+	return &DynamoError{
+		Op:    op,
+		Table: table,
+		Kind:  ErrThrottled,
+		Err:   fmt.Errorf("%v items still unprocessed after %v retries", count, DefaultDynamoBatchRetries),
+	}
+}
+
+// uniqueKeyAttributes is the attributes of the keys without repeats, which DynamoDB rejects
+// in a batch
+func uniqueKeyAttributes(keys []Key) ([]map[string]types.AttributeValue, error) {
+	seen := map[string]bool{}
+	result := make([]map[string]types.AttributeValue, 0, len(keys))
+	for _, key := range keys {
+		attrs, err := key.attributes()
+		if err != nil {
+			return nil, err
+		}
+		id, err := attributesId(attrs)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, attrs)
+		}
+	}
+	return result, nil
+}
+
+// attributesId identifies an item by its key attributes
+func attributesId(attrs map[string]types.AttributeValue) (string, error) {
+	data, err := marshalKeyJSON(attrs)
+	return string(data), err
+}
+
+// keyNames reads the names of the key attributes of the table, once
+func (d *Dynamo[T]) keyNames(ctx context.Context) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.keySchema != nil {
+		return d.keySchema, nil
+	}
+
+	result, err := d.Client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(d.Table),
+	})
+	if err != nil {
+		return nil, mapDynamoError(ctx, "DescribeTable", d.Table, "", err)
+	}
+	names := []string{}
+	for _, element := range result.Table.KeySchema {
+		names = append(names, aws.ToString(element.AttributeName))
+	}
+	d.keySchema = names
+	return names, nil
+}
@@ -0,0 +1,110 @@
+package cloudyaws
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDynamoBatch(t *testing.T) {
+	ctx := context.Background()
+	stub := newFakeDynamo(t)
+	stub.createTable("orders", "customer", "order_id")
+	orders := newFakeDynamoTable[testOrder](t, stub, "orders")
+
+	// 60 items take 3 writes
+	var items []*testOrder
+	var keys []Key
+	for id := 1; id <= 60; id++ {
+		items = append(items, &testOrder{Customer: "c1", OrderId: id, Status: "open"})
+		keys = append(keys, PartitionKey("customer", "c1").WithSort("order_id", id))
+	}
+	assert.Nil(t, orders.BatchSave(ctx, items))
+	assert.Equal(t, 3, stub.calls["BatchWriteItem"])
+
+	// Repeated and missing keys are left out
+	found, err := orders.BatchGet(ctx, append(keys, keys[0], PartitionKey("customer", "c2").WithSort("order_id", 1)))
+	assert.Nil(t, err)
+	sortOrders(found)
+	assert.Len(t, found, 60)
+	assert.Equal(t, 60, found[59].OrderId)
+	assert.Equal(t, 1, stub.calls["BatchGetItem"])
+
+	found, err = orders.BatchGet(ctx, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, found)
+
+	assert.Nil(t, orders.BatchDelete(ctx, keys[:30]))
+	page, err := orders.Query(ctx, PartitionEquals("customer", "c1"), QueryOptions{}, "")
+	assert.Nil(t, err)
+	assert.Len(t, page.Items, 30)
+	assert.Equal(t, 31, page.Items[0].OrderId)
+
+	// Repeated keys save the last item
+	assert.Nil(t, orders.BatchSave(ctx, []*testOrder{
+		{Customer: "c3", OrderId: 1, Status: "open"},
+		{Customer: "c3", OrderId: 2, Status: "open"},
+		{Customer: "c3", OrderId: 1, Status: "shipped"},
+	}))
+	order, err := orders.Get(ctx, PartitionKey("customer", "c3").WithSort("order_id", 1))
+	assert.Nil(t, err)
+	assert.Equal(t, "shipped", order.Status)
+	assert.Equal(t, 1, stub.calls["DescribeTable"])
+
+	_, err = orders.BatchGet(ctx, []Key{PartitionKey("customer", "")})
+	assert.True(t, errors.Is(err, ErrInvalidKey))
+
+	stub.fail["BatchWriteItem"] = "AccessDeniedException"
+	err = orders.BatchDelete(ctx, keys)
+	assert.True(t, errors.Is(err, ErrAccessDenied))
+
+	orders.OptimisticLocking = true
+	assert.NotNil(t, orders.BatchSave(ctx, items))
+}
+
+func TestDynamoBatchUnprocessed(t *testing.T) {
+	ctx := context.Background()
+	stub := newFakeDynamo(t)
+	stub.createTable("orders", "customer", "order_id")
+	orders := newFakeDynamoTable[testOrder](t, stub, "orders")
+
+	var items []*testOrder
+	var keys []Key
+	for id := 1; id <= 3; id++ {
+		items = append(items, &testOrder{Customer: "c1", OrderId: id})
+		keys = append(keys, PartitionKey("customer", "c1").WithSort("order_id", id))
+	}
+
+	// Unprocessed items are sent again until none are left
+	stub.unprocessed["BatchWriteItem"] = 1
+	assert.Nil(t, orders.BatchSave(ctx, items))
+	assert.Equal(t, 2, stub.calls["BatchWriteItem"])
+	assert.Len(t, stub.tables["orders"].Items, 3)
+
+	stub.unprocessed["BatchGetItem"] = 1
+	found, err := orders.BatchGet(ctx, keys)
+	assert.Nil(t, err)
+	assert.Len(t, found, 3)
+	assert.Equal(t, 2, stub.calls["BatchGetItem"])
+
+	// Waiting to send them again stops with the context. The backoff is random, so how many
+	// calls fit in the timeout is not known.
+	stub.unprocessed["BatchWriteItem"] = 100
+	stub.unprocessed["BatchGetItem"] = 100
+	for op, batch := range map[string]func(ctx context.Context) error{
+		"BatchWriteItem": func(ctx context.Context) error { return orders.BatchDelete(ctx, keys) },
+		"BatchGetItem":   func(ctx context.Context) error { _, err := orders.BatchGet(ctx, keys); return err },
+	} {
+		calls := stub.calls[op]
+		timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		start := time.Now()
+		err = batch(timeout)
+		cancel()
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), op)
+		assert.Less(t, time.Since(start), time.Second, op)
+		assert.Greater(t, stub.calls[op], calls, op)
+	}
+}
@@ -0,0 +1,83 @@
+package cloudyaws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/appliedres/cloudy"
+	"github.com/aws/smithy-go"
+)
+
+// Categories of Dynamo failures, test with errors.Is. ErrThrottled and ErrAccessDenied are
+// shared with the secret providers.
+var (
+	ErrItemNotFound        = errors.New("item not found")
+	ErrInvalidKey          = errors.New("invalid key")
+	ErrInvalidPageToken    = errors.New("invalid page token")
+	ErrConditionFailed     = errors.New("condition failed")
+	ErrTransactionConflict = errors.New("transaction conflict")
+)
+
+// DynamoError is returned by every Dynamo call that fails in DynamoDB
+type DynamoError struct {
+	Op    string // DynamoDB operation, e.g. "GetItem"
+	Table string
+	Key   string // Empty for operations on more than one item
+	Kind  error  // One of the Err* categories, nil when the failure has none
+	Err   error  // Error returned by the SDK, nil when DynamoDB did not fail
+}
+
+func (e *DynamoError) Error() string {
+	msg := fmt.Sprintf("%v %v", e.Op, e.Table)
+	if e.Key != "" {
+		msg += fmt.Sprintf(" [%v]", e.Key)
+	}
+	if e.Kind != nil {
+		msg += fmt.Sprintf(": %v", e.Kind)
+	}
+	if e.Err != nil {
+		msg += fmt.Sprintf(": %v", e.Err)
+	}
+	return msg
+}
+
+func (e *DynamoError) Unwrap() []error {
+	var errs []error
+	for _, err := range []error{e.Kind, e.Err} {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// Error codes for each category
+var dynamoErrorKinds = map[string]error{
+	"ConditionalCheckFailedException":        ErrConditionFailed,
+	"ProvisionedThroughputExceededException": ErrThrottled,
+	"RequestLimitExceeded":                   ErrThrottled,
+	"ThrottlingException":                    ErrThrottled,
+	"TransactionConflictException":           ErrTransactionConflict,
+	"AccessDeniedException":                  ErrAccessDenied,
+}
+
+// mapDynamoError wraps an SDK error in a *DynamoError with its category
+func mapDynamoError(ctx context.Context, op string, table string, key string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var dynamoErr *DynamoError
+	if errors.As(err, &dynamoErr) {
+		return err
+	}
+
+	dynamoErr = &DynamoError{Op: op, Table: table, Key: key, Err: err}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		dynamoErr.Kind = dynamoErrorKinds[apiErr.ErrorCode()]
+	}
+
+	cloudy.Info(ctx, "AWS Dynamo: %v", dynamoErr)
+	return dynamoErr
+}
@@ -0,0 +1,141 @@
+package cloudyaws
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Key identifies an item by its partition key and, on tables that have one, its sort key.
+// Values are strings (S), numbers of any Go numeric type or json.Number (N), or []byte (B).
+type Key struct {
+	PartitionName  string
+	PartitionValue interface{}
+	SortName       string // Empty for tables without a sort key
+	SortValue      interface{}
+}
+
+// PartitionKey is the key of an item in a table without a sort key
+func PartitionKey(name string, value interface{}) Key {
+	return Key{PartitionName: name, PartitionValue: value}
+}
+
+// WithSort adds the sort key of a composite key
+func (k Key) WithSort(name string, value interface{}) Key {
+	k.SortName = name
+	k.SortValue = value
+	return k
+}
+
+func (k Key) String() string {
+	if k.SortName == "" {
+		return fmt.Sprintf("%v=%v", k.PartitionName, k.PartitionValue)
+	}
+	return fmt.Sprintf("%v=%v, %v=%v", k.PartitionName, k.PartitionValue, k.SortName, k.SortValue)
+}
+
+// attributes is the key as the Key of a DynamoDB request
+func (k Key) attributes() (map[string]types.AttributeValue, error) {
+	if k.PartitionName == "" {
+		return nil, fmt.Errorf("%w: no partition key", ErrInvalidKey)
+	}
+	partition, err := keyAttribute(k.PartitionName, k.PartitionValue)
+	if err != nil {
+		return nil, err
+	}
+	attrs := map[string]types.AttributeValue{k.PartitionName: partition}
+
+	if k.SortName != "" {
+		sort, err := keyAttribute(k.SortName, k.SortValue)
+		if err != nil {
+			return nil, err
+		}
+		attrs[k.SortName] = sort
+	}
+	return attrs, nil
+}
+
+// keyAttribute marshals a key value, which must be a non-empty S, N or B
+func keyAttribute(name string, value interface{}) (types.AttributeValue, error) {
+	av, err := attributevalue.Marshal(toDynamoNumber(value))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v: %w", ErrInvalidKey, name, err)
+	}
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		if v.Value != "" {
+			return av, nil
+		}
+	case *types.AttributeValueMemberN:
+		return av, nil
+	case *types.AttributeValueMemberB:
+		if len(v.Value) > 0 {
+			return av, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %v must be a non-empty string, number or binary, not %T", ErrInvalidKey, name, value)
+}
+
+// keyValue is a key value in an expression
+func keyValue(value interface{}) expression.ValueBuilder {
+	return expression.Value(toDynamoNumber(value))
+}
+
+// toDynamoNumber turns a json.Number into a number, which marshalling would make a string
+func toDynamoNumber(value interface{}) interface{} {
+	if n, ok := value.(json.Number); ok {
+		return attributevalue.Number(n)
+	}
+	return value
+}
+
+// jsonKeyAttribute is a key attribute in JSON, the form of page tokens. It is the form the v1
+// SDK gave them, so their tokens keep working.
+type jsonKeyAttribute struct {
+	S *string `json:",omitempty"`
+	N *string `json:",omitempty"`
+	B []byte  `json:",omitempty"`
+}
+
+// marshalKeyJSON encodes the attributes of a key, which are all S, N or B
+func marshalKeyJSON(attrs map[string]types.AttributeValue) ([]byte, error) {
+	key := make(map[string]jsonKeyAttribute, len(attrs))
+	for name, av := range attrs {
+		switch v := av.(type) {
+		case *types.AttributeValueMemberS:
+			key[name] = jsonKeyAttribute{S: &v.Value}
+		case *types.AttributeValueMemberN:
+			key[name] = jsonKeyAttribute{N: &v.Value}
+		case *types.AttributeValueMemberB:
+			key[name] = jsonKeyAttribute{B: v.Value}
+		default:
+			return nil, fmt.Errorf("%w: %v is a %T", ErrInvalidKey, name, av)
+		}
+	}
+	return json.Marshal(key)
+}
+
+// unmarshalKeyJSON decodes the attributes of a key encoded by marshalKeyJSON
+func unmarshalKeyJSON(data []byte) (map[string]types.AttributeValue, error) {
+	var key map[string]jsonKeyAttribute
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, err
+	}
+	attrs := make(map[string]types.AttributeValue, len(key))
+	for name, attr := range key {
+		switch {
+		case attr.S != nil:
+			attrs[name] = &types.AttributeValueMemberS{Value: *attr.S}
+		case attr.N != nil:
+			attrs[name] = &types.AttributeValueMemberN{Value: *attr.N}
+		case attr.B != nil:
+			attrs[name] = &types.AttributeValueMemberB{Value: attr.B}
+		default:
+			return nil, fmt.Errorf("%v is not a string, number or binary", name)
+		}
+	}
+	return attrs, nil
+}
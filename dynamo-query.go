@@ -0,0 +1,302 @@
+package cloudyaws
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"iter"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DynamoPage is one page of a Query or Scan
+type DynamoPage[T any] struct {
+	Items     []T
+	NextToken string // Pass back for the next page, "" on the last page
+}
+
+// KeyCondition selects the items of a Query: those with one partition key value and,
+// optionally, sort key values matching a condition
+type KeyCondition struct {
+	partition expression.KeyConditionBuilder
+	sort      *expression.KeyConditionBuilder
+}
+
+// PartitionEquals selects the items whose partition key is value
+func PartitionEquals(name string, value interface{}) KeyCondition {
+	return KeyCondition{partition: expression.Key(name).Equal(keyValue(value))}
+}
+
+// SortEquals narrows to the item whose sort key is value
+func (c KeyCondition) SortEquals(name string, value interface{}) KeyCondition {
+	sort := expression.Key(name).Equal(keyValue(value))
+	c.sort = &sort
+	return c
+}
+
+// SortBeginsWith narrows to the items whose string sort key starts with prefix
+func (c KeyCondition) SortBeginsWith(name string, prefix string) KeyCondition {
+	sort := expression.Key(name).BeginsWith(prefix)
+	c.sort = &sort
+	return c
+}
+
+// SortBetween narrows to the items whose sort key is from low to high, inclusive
+func (c KeyCondition) SortBetween(name string, low interface{}, high interface{}) KeyCondition {
+	sort := expression.Key(name).Between(keyValue(low), keyValue(high))
+	c.sort = &sort
+	return c
+}
+
+func (c KeyCondition) builder() expression.KeyConditionBuilder {
+	if c.sort == nil {
+		return c.partition
+	}
+	return c.partition.And(*c.sort)
+}
+
+// QueryOptions are the optional parts of a Query
+type QueryOptions struct {
+	// Global or local secondary index to query, the table when empty
+	Index string
+	// Applied to the items read, after Limit
+	Filter *expression.ConditionBuilder
+	// Return items by sort key from highest to lowest
+	Descending bool
+	// Items read per page, before the filter. 0 reads up to 1 MB a page.
+	Limit int
+	// Strongly consistent read, not supported on global secondary indexes
+	ConsistentRead bool
+}
+
+// ScanOptions are the optional parts of a Scan
+type ScanOptions struct {
+	// Global or local secondary index to scan, the table when empty
+	Index string
+	// Applied to the items read, after Limit
+	Filter *expression.ConditionBuilder
+	// Items read per page, before the filter. 0 reads up to 1 MB a page.
+	Limit int
+	// Strongly consistent read, not supported on global secondary indexes
+	ConsistentRead bool
+	// Scan only Segment of TotalSegments, see ParallelScan
+	Segment       int
+	TotalSegments int
+}
+
+// Query reads one page of the items matching the key condition. An empty token starts from
+// the beginning. A page can be empty and still have a NextToken when the filter removed
+// every item read.
+func (d *Dynamo[T]) Query(ctx context.Context, cond KeyCondition, opts QueryOptions, token string) (*DynamoPage[T], error) {
+	builder := expression.NewBuilder().WithKeyCondition(cond.builder())
+	if opts.Filter != nil {
+		builder = builder.WithFilter(*opts.Filter)
+	}
+	expr, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	startKey, err := decodePageToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(d.Table),
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ScanIndexForward:          aws.Bool(!opts.Descending),
+		ExclusiveStartKey:         startKey,
+	}
+	if opts.Index != "" {
+		input.IndexName = aws.String(opts.Index)
+	}
+	if opts.Limit > 0 {
+		input.Limit = aws.Int32(int32(opts.Limit))
+	}
+	if opts.ConsistentRead {
+		input.ConsistentRead = aws.Bool(true)
+	}
+
+	result, err := d.Client.Query(ctx, input)
+	if err != nil {
+		return nil, mapDynamoError(ctx, "Query", d.Table, "", err)
+	}
+	return newDynamoPage[T](result.Items, result.LastEvaluatedKey)
+}
+
+// QueryAll iterates over every item matching the key condition, reading pages as needed.
+// Iteration stops after the first error.
+func (d *Dynamo[T]) QueryAll(ctx context.Context, cond KeyCondition, opts QueryOptions) iter.Seq2[T, error] {
+	return allPages(func(token string) (*DynamoPage[T], error) {
+		return d.Query(ctx, cond, opts, token)
+	})
+}
+
+// Scan reads one page of the table or index. An empty token starts from the beginning.
+func (d *Dynamo[T]) Scan(ctx context.Context, opts ScanOptions, token string) (*DynamoPage[T], error) {
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(d.Table),
+	}
+	if opts.Filter != nil {
+		expr, err := expression.NewBuilder().WithFilter(*opts.Filter).Build()
+		if err != nil {
+			return nil, err
+		}
+		input.FilterExpression = expr.Filter()
+		input.ExpressionAttributeNames = expr.Names()
+		input.ExpressionAttributeValues = expr.Values()
+	}
+	startKey, err := decodePageToken(token)
+	if err != nil {
+		return nil, err
+	}
+	input.ExclusiveStartKey = startKey
+
+	if opts.Index != "" {
+		input.IndexName = aws.String(opts.Index)
+	}
+	if opts.Limit > 0 {
+		input.Limit = aws.Int32(int32(opts.Limit))
+	}
+	if opts.ConsistentRead {
+		input.ConsistentRead = aws.Bool(true)
+	}
+	if opts.TotalSegments > 0 {
+		input.Segment = aws.Int32(int32(opts.Segment))
+		input.TotalSegments = aws.Int32(int32(opts.TotalSegments))
+	}
+
+	result, err := d.Client.Scan(ctx, input)
+	if err != nil {
+		return nil, mapDynamoError(ctx, "Scan", d.Table, "", err)
+	}
+	return newDynamoPage[T](result.Items, result.LastEvaluatedKey)
+}
+
+// ScanAll iterates over every item of the table or index, reading pages as needed.
+// Iteration stops after the first error.
+func (d *Dynamo[T]) ScanAll(ctx context.Context, opts ScanOptions) iter.Seq2[T, error] {
+	return allPages(func(token string) (*DynamoPage[T], error) {
+		return d.Scan(ctx, opts, token)
+	})
+}
+
+// ParallelScan reads the whole table or index as segments scanned at the same time and
+// returns the items in no particular order. The Segment options are set for each segment.
+func (d *Dynamo[T]) ParallelScan(ctx context.Context, opts ScanOptions, segments int) ([]T, error) {
+	if segments < 1 {
+		return nil, fmt.Errorf("parallel scan needs at least 1 segment, not %v", segments)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([][]T, segments)
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for segment := 0; segment < segments; segment++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			segmentOpts := opts
+			segmentOpts.Segment = segment
+			segmentOpts.TotalSegments = segments
+			for item, err := range d.ScanAll(ctx, segmentOpts) {
+				if err != nil {
+					// The other segments fail with the cancellation, keep the cause
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					cancel()
+					return
+				}
+				results[segment] = append(results[segment], item)
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	var items []T
+	for _, segment := range results {
+		items = append(items, segment...)
+	}
+	return items, nil
+}
+
+// allPages iterates over the items of the pages returned by fetch
+func allPages[T any](fetch func(token string) (*DynamoPage[T], error)) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		token := ""
+		for {
+			page, err := fetch(token)
+			if err != nil {
+				var zero T
+				yield(zero, err)
+				return
+			}
+			for _, item := range page.Items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+			if page.NextToken == "" {
+				return
+			}
+			token = page.NextToken
+		}
+	}
+}
+
+func newDynamoPage[T any](items []map[string]types.AttributeValue, lastKey map[string]types.AttributeValue) (*DynamoPage[T], error) {
+	page := &DynamoPage[T]{Items: make([]T, 0, len(items))}
+	if err := attributevalue.UnmarshalListOfMaps(items, &page.Items); err != nil {
+		return nil, err
+	}
+	token, err := encodePageToken(lastKey)
+	if err != nil {
+		return nil, err
+	}
+	page.NextToken = token
+	return page, nil
+}
+
+// encodePageToken makes a LastEvaluatedKey an opaque string
+func encodePageToken(key map[string]types.AttributeValue) (string, error) {
+	if len(key) == 0 {
+		return "", nil
+	}
+	data, err := marshalKeyJSON(key)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodePageToken is the ExclusiveStartKey of a token, nil for ""
+func decodePageToken(token string) (map[string]types.AttributeValue, error) {
+	if token == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPageToken, err)
+	}
+	key, err := unmarshalKeyJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPageToken, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,176 @@
+package cloudyaws
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestOrders(t *testing.T) (*Dynamo[testOrder], *fakeDynamo) {
+	stub := newFakeDynamo(t)
+	table := stub.createTable("orders", "customer", "order_id")
+	table.Indexes["by_status"] = fakeIndex{Partition: "status", Sort: "total"}
+	orders := newFakeDynamoTable[testOrder](t, stub, "orders")
+
+	for _, order := range []testOrder{
+		{Customer: "c1", OrderId: 1, Status: "open", Total: 10},
+		{Customer: "c1", OrderId: 2, Status: "shipped", Total: 25},
+		{Customer: "c1", OrderId: 3, Status: "open", Total: 5},
+		{Customer: "c1", OrderId: 10, Status: "open", Total: 40},
+		{Customer: "c2", OrderId: 1, Status: "open", Total: 15},
+		{Customer: "c2", OrderId: 2, Status: "cancelled", Total: 30},
+	} {
+		assert.Nil(t, orders.Save(&order))
+	}
+	return orders, stub
+}
+
+func orderIds(orders []testOrder) []int {
+	ids := []int{}
+	for _, order := range orders {
+		ids = append(ids, order.OrderId)
+	}
+	return ids
+}
+
+func sortOrders(orders []testOrder) {
+	sort.Slice(orders, func(i, j int) bool {
+		if orders[i].Customer != orders[j].Customer {
+			return orders[i].Customer < orders[j].Customer
+		}
+		return orders[i].OrderId < orders[j].OrderId
+	})
+}
+
+func TestDynamoQuery(t *testing.T) {
+	ctx := context.Background()
+	orders, _ := newTestOrders(t)
+
+	page, err := orders.Query(ctx, PartitionEquals("customer", "c1"), QueryOptions{}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 2, 3, 10}, orderIds(page.Items))
+	assert.Empty(t, page.NextToken)
+
+	page, err = orders.Query(ctx, PartitionEquals("customer", "c1"), QueryOptions{Descending: true}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{10, 3, 2, 1}, orderIds(page.Items))
+
+	page, err = orders.Query(ctx, PartitionEquals("customer", "c1").SortBetween("order_id", 2, 3), QueryOptions{}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2, 3}, orderIds(page.Items))
+
+	page, err = orders.Query(ctx, PartitionEquals("customer", "c2").SortEquals("order_id", 2), QueryOptions{}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "cancelled", page.Items[0].Status)
+
+	open := expression.Name("status").Equal(expression.Value("open"))
+	page, err = orders.Query(ctx, PartitionEquals("customer", "c1"), QueryOptions{Filter: &open}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 3, 10}, orderIds(page.Items))
+
+	// Pages follow on with the token, the filter applies after the limit
+	page, err = orders.Query(ctx, PartitionEquals("customer", "c1"), QueryOptions{Filter: &open, Limit: 2}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1}, orderIds(page.Items))
+	assert.NotEmpty(t, page.NextToken)
+	page, err = orders.Query(ctx, PartitionEquals("customer", "c1"), QueryOptions{Filter: &open, Limit: 2}, page.NextToken)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{3, 10}, orderIds(page.Items))
+	assert.Empty(t, page.NextToken)
+
+	// Tokens from before the move to the v2 SDK still work
+	v1Token := base64.RawURLEncoding.EncodeToString([]byte(`{"customer":{"B":null,"BOOL":null,"BS":null,"L":null,"M":null,"N":null,"NS":null,"NULL":null,"S":"c1","SS":null},` +
+		`"order_id":{"B":null,"BOOL":null,"BS":null,"L":null,"M":null,"N":"2","NS":null,"NULL":null,"S":null,"SS":null}}`))
+	page, err = orders.Query(ctx, PartitionEquals("customer", "c1"), QueryOptions{Filter: &open, Limit: 2}, v1Token)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{3, 10}, orderIds(page.Items))
+
+	// Global secondary index
+	page, err = orders.Query(ctx, PartitionEquals("status", "open").SortBetween("total", 10, 20), QueryOptions{Index: "by_status"}, "")
+	assert.Nil(t, err)
+	assert.Len(t, page.Items, 2)
+	assert.Equal(t, 10.0, page.Items[0].Total)
+	assert.Equal(t, 15.0, page.Items[1].Total)
+
+	_, err = orders.Query(ctx, PartitionEquals("customer", "c1"), QueryOptions{}, "not a token!")
+	assert.True(t, errors.Is(err, ErrInvalidPageToken))
+}
+
+func TestDynamoQueryBeginsWith(t *testing.T) {
+	ctx := context.Background()
+	stub := newFakeDynamo(t)
+	stub.createTable("events", "stream", "at")
+	type event struct {
+		Stream string `dynamodbav:"stream"`
+		At     string `dynamodbav:"at"`
+	}
+	events := newFakeDynamoTable[event](t, stub, "events")
+	for _, at := range []string{"2024-01-05", "2024-02-01", "2024-02-14", "2024-03-01"} {
+		assert.Nil(t, events.Save(&event{Stream: "s", At: at}))
+	}
+
+	page, err := events.Query(ctx, PartitionEquals("stream", "s").SortBeginsWith("at", "2024-02"), QueryOptions{}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []event{{"s", "2024-02-01"}, {"s", "2024-02-14"}}, page.Items)
+}
+
+func TestDynamoQueryAll(t *testing.T) {
+	ctx := context.Background()
+	orders, stub := newTestOrders(t)
+
+	var ids []int
+	for order, err := range orders.QueryAll(ctx, PartitionEquals("customer", "c1"), QueryOptions{Limit: 1}) {
+		assert.Nil(t, err)
+		ids = append(ids, order.OrderId)
+	}
+	assert.Equal(t, []int{1, 2, 3, 10}, ids)
+	assert.Equal(t, 4, stub.calls["Query"])
+
+	// Stopping early reads no more pages
+	for range orders.QueryAll(ctx, PartitionEquals("customer", "c1"), QueryOptions{Limit: 1}) {
+		break
+	}
+	assert.Equal(t, 5, stub.calls["Query"])
+
+	stub.fail["Query"] = "ProvisionedThroughputExceededException"
+	for _, err := range orders.QueryAll(ctx, PartitionEquals("customer", "c1"), QueryOptions{}) {
+		assert.True(t, errors.Is(err, ErrThrottled))
+	}
+}
+
+func TestDynamoScan(t *testing.T) {
+	ctx := context.Background()
+	orders, stub := newTestOrders(t)
+
+	var all []testOrder
+	for order, err := range orders.ScanAll(ctx, ScanOptions{Limit: 4}) {
+		assert.Nil(t, err)
+		all = append(all, order)
+	}
+	assert.Len(t, all, 6)
+	assert.Equal(t, 2, stub.calls["Scan"])
+
+	cancelled := expression.Name("status").Equal(expression.Value("cancelled"))
+	page, err := orders.Scan(ctx, ScanOptions{Filter: &cancelled}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2}, orderIds(page.Items))
+	assert.Equal(t, "c2", page.Items[0].Customer)
+
+	items, err := orders.ParallelScan(ctx, ScanOptions{Limit: 1}, 3)
+	assert.Nil(t, err)
+	sortOrders(all)
+	sortOrders(items)
+	assert.Equal(t, all, items)
+
+	stub.fail["Scan"] = "AccessDeniedException"
+	_, err = orders.ParallelScan(ctx, ScanOptions{}, 4)
+	assert.True(t, errors.Is(err, ErrAccessDenied))
+
+	_, err = orders.ParallelScan(ctx, ScanOptions{}, 0)
+	assert.NotNil(t, err)
+}
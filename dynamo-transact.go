@@ -0,0 +1,233 @@
+package cloudyaws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/appliedres/cloudy"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Most operations TransactWriteItems takes in one call
+const dynamoTransactSize = 100
+
+// TransactOp is one write or check of a transaction, made by the Transact* methods of a
+// Dynamo and run with Transact
+type TransactOp struct {
+	client  *dynamodb.Client
+	table   string
+	key     string
+	item    *types.TransactWriteItem
+	version *itemVersion // Incremented when the transaction succeeds
+	err     error
+}
+
+// TransactPut creates or replaces an item in a transaction. With OptimisticLocking the
+// version is checked, and incremented on item when the transaction succeeds.
+func (d *Dynamo[T]) TransactPut(item *T, opts SaveItemOptions) TransactOp {
+	op := TransactOp{client: d.Client, table: d.Table}
+
+	cond := opts.Condition
+	if d.OptimisticLocking {
+		version, err := findVersion(item)
+		if err != nil {
+			op.err = err
+			return op
+		}
+		versionCond := version.condition()
+		if cond != nil {
+			versionCond = cond.And(versionCond)
+		}
+		cond = &versionCond
+		op.version = version
+	}
+
+	// The stored item has the next version, item keeps its own until the transaction succeeds
+	if op.version != nil {
+		op.version.increment()
+	}
+	itemMap, err := attributevalue.MarshalMap(item)
+	if op.version != nil {
+		op.version.restore()
+	}
+	if err != nil {
+		op.err = err
+		return op
+	}
+
+	put := &types.Put{
+		TableName: aws.String(d.Table),
+		Item:      itemMap,
+	}
+	if cond != nil {
+		expr, err := expression.NewBuilder().WithCondition(*cond).Build()
+		if err != nil {
+			op.err = err
+			return op
+		}
+		put.ConditionExpression = expr.Condition()
+		put.ExpressionAttributeNames = expr.Names()
+		put.ExpressionAttributeValues = expr.Values()
+	}
+	op.item = &types.TransactWriteItem{Put: put}
+	return op
+}
+
+// TransactUpdate changes the attributes of an existing item in a transaction, see Update. A
+// missing item fails the transaction with ErrConditionFailed.
+func (d *Dynamo[T]) TransactUpdate(key Key, ops ...UpdateOp) TransactOp {
+	op := TransactOp{client: d.Client, table: d.Table, key: key.String()}
+	if len(ops) == 0 {
+		op.err = fmt.Errorf("update of %v in %v has no changes", key, d.Table)
+		return op
+	}
+	keyAttrs, err := key.attributes()
+	if err != nil {
+		op.err = err
+		return op
+	}
+	expr, err := d.buildUpdate(key, ops)
+	if err != nil {
+		op.err = err
+		return op
+	}
+
+	op.item = &types.TransactWriteItem{Update: &types.Update{
+		TableName:                 aws.String(d.Table),
+		Key:                       keyAttrs,
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}}
+	return op
+}
+
+// TransactDelete removes an item in a transaction. When cond is not nil the item must meet
+// it or the transaction fails with ErrConditionFailed.
+func (d *Dynamo[T]) TransactDelete(key Key, cond *expression.ConditionBuilder) TransactOp {
+	op := TransactOp{client: d.Client, table: d.Table, key: key.String()}
+	keyAttrs, err := key.attributes()
+	if err != nil {
+		op.err = err
+		return op
+	}
+
+	del := &types.Delete{
+		TableName: aws.String(d.Table),
+		Key:       keyAttrs,
+	}
+	if cond != nil {
+		expr, err := expression.NewBuilder().WithCondition(*cond).Build()
+		if err != nil {
+			op.err = err
+			return op
+		}
+		del.ConditionExpression = expr.Condition()
+		del.ExpressionAttributeNames = expr.Names()
+		del.ExpressionAttributeValues = expr.Values()
+	}
+	op.item = &types.TransactWriteItem{Delete: del}
+	return op
+}
+
+// TransactCheck fails the transaction with ErrConditionFailed unless the item meets cond,
+// without changing it
+func (d *Dynamo[T]) TransactCheck(key Key, cond expression.ConditionBuilder) TransactOp {
+	op := TransactOp{client: d.Client, table: d.Table, key: key.String()}
+	keyAttrs, err := key.attributes()
+	if err != nil {
+		op.err = err
+		return op
+	}
+	expr, err := expression.NewBuilder().WithCondition(cond).Build()
+	if err != nil {
+		op.err = err
+		return op
+	}
+
+	op.item = &types.TransactWriteItem{ConditionCheck: &types.ConditionCheck{
+		TableName:                 aws.String(d.Table),
+		Key:                       keyAttrs,
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}}
+	return op
+}
+
+// Transact runs the ops, on one or more tables, so that either all of them succeed or none
+// do. A failed condition is an ErrConditionFailed for the op that failed, a transaction
+// running into another one on the same items is an ErrTransactionConflict. All tables must
+// be in the same account and region, and their Dynamo must share one Client.
+func Transact(ctx context.Context, ops ...TransactOp) error {
+	if len(ops) == 0 {
+		return errors.New("transaction has no operations")
+	}
+	if len(ops) > dynamoTransactSize {
+		return fmt.Errorf("transaction has %v operations, at most %v are allowed", len(ops), dynamoTransactSize)
+	}
+
+	items := make([]types.TransactWriteItem, 0, len(ops))
+	for _, op := range ops {
+		if op.err != nil {
+			return op.err
+		}
+		if op.item == nil {
+			return errors.New("transaction operations must be made by a Dynamo")
+		}
+		if op.client != ops[0].client {
+			return fmt.Errorf("transaction operation on %v uses a different DynamoDB client than %v, the tables of a transaction must share one", op.table, ops[0].table)
+		}
+		items = append(items, *op.item)
+	}
+
+	_, err := ops[0].client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if err != nil {
+		return mapTransactError(ctx, ops, err)
+	}
+
+	for _, op := range ops {
+		if op.version != nil {
+			op.version.increment()
+		}
+	}
+	return nil
+}
+
+// Cancellation reasons of a transaction with a category
+var transactCancelKinds = map[string]error{
+	"ConditionalCheckFailed":        ErrConditionFailed,
+	"TransactionConflict":           ErrTransactionConflict,
+	"ProvisionedThroughputExceeded": ErrThrottled,
+	"ThrottlingError":               ErrThrottled,
+}
+
+// mapTransactError blames a cancelled transaction on the first op with a reason
+func mapTransactError(ctx context.Context, ops []TransactOp, err error) error {
+	var cancelled *types.TransactionCanceledException
+	if errors.As(err, &cancelled) {
+		for i, reason := range cancelled.CancellationReasons {
+			kind := transactCancelKinds[aws.ToString(reason.Code)]
+			if kind != nil && i < len(ops) {
+				dynamoErr := &DynamoError{
+					Op:    "TransactWriteItems",
+					Table: ops[i].table,
+					Key:   ops[i].key,
+					Kind:  kind,
+					Err:   err,
+				}
+				cloudy.Info(ctx, "AWS Dynamo: %v", dynamoErr)
+				return dynamoErr
+			}
+		}
+	}
+	return mapDynamoError(ctx, "TransactWriteItems", "", "", err)
+}
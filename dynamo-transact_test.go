@@ -0,0 +1,110 @@
+package cloudyaws
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDynamoTransact(t *testing.T) {
+	ctx := context.Background()
+	stub := newFakeDynamo(t)
+	stub.createTable("accounts", "id", "")
+	stub.createTable("orders", "customer", "order_id")
+	accounts := newFakeDynamoTable[testAccount](t, stub, "accounts")
+	orders := &Dynamo[testOrder]{Client: accounts.Client, Table: "orders"}
+
+	assert.Nil(t, accounts.Save(&testAccount{Id: "a", Balance: 100}))
+	assert.Nil(t, orders.Save(&testOrder{Customer: "a", OrderId: 1, Status: "open"}))
+
+	// Across tables
+	enough := expression.Name("balance").GreaterThanEqual(expression.Value(30))
+	err := Transact(ctx,
+		accounts.TransactCheck(PartitionKey("id", "a"), enough),
+		accounts.TransactUpdate(PartitionKey("id", "a"), UpdateAdd("balance", -30)),
+		orders.TransactPut(&testOrder{Customer: "a", OrderId: 2, Status: "paid", Total: 30}, SaveItemOptions{}),
+		orders.TransactDelete(PartitionKey("customer", "a").WithSort("order_id", 1), nil),
+	)
+	assert.Nil(t, err)
+	account, err := accounts.Get(ctx, PartitionKey("id", "a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 70, account.Balance)
+	page, err := orders.Query(ctx, PartitionEquals("customer", "a"), QueryOptions{}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2}, orderIds(page.Items))
+
+	// One failed condition and nothing changes
+	err = Transact(ctx,
+		accounts.TransactUpdate(PartitionKey("id", "a"), UpdateAdd("balance", -30)),
+		orders.TransactPut(&testOrder{Customer: "a", OrderId: 2}, SaveItemOptions{Condition: ItemNotExists("customer")}),
+	)
+	assert.True(t, errors.Is(err, ErrConditionFailed))
+	var dynamoErr *DynamoError
+	assert.True(t, errors.As(err, &dynamoErr))
+	assert.Equal(t, "orders", dynamoErr.Table)
+	account, err = accounts.Get(ctx, PartitionKey("id", "a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 70, account.Balance)
+
+	// Updates need the item
+	err = Transact(ctx, accounts.TransactUpdate(PartitionKey("id", "b"), UpdateSet("balance", 1)))
+	assert.True(t, errors.Is(err, ErrConditionFailed))
+	_, err = accounts.Get(ctx, PartitionKey("id", "b"))
+	assert.True(t, errors.Is(err, ErrItemNotFound))
+
+	stub.fail["TransactWriteItems"] = "TransactionConflictException"
+	err = Transact(ctx, accounts.TransactDelete(PartitionKey("id", "a"), nil))
+	assert.True(t, errors.Is(err, ErrTransactionConflict))
+
+	// Invalid ops fail before calling DynamoDB
+	calls := stub.calls["TransactWriteItems"]
+	assert.NotNil(t, Transact(ctx))
+	assert.NotNil(t, Transact(ctx, TransactOp{}))
+	assert.True(t, errors.Is(Transact(ctx, accounts.TransactDelete(PartitionKey("id", ""), nil)), ErrInvalidKey))
+	assert.NotNil(t, Transact(ctx, accounts.TransactUpdate(PartitionKey("id", "a"))))
+	assert.NotNil(t, Transact(ctx, make([]TransactOp, 101)...))
+	otherClient := newFakeDynamoTable[testOrder](t, stub, "orders")
+	assert.NotNil(t, Transact(ctx,
+		accounts.TransactDelete(PartitionKey("id", "a"), nil),
+		otherClient.TransactDelete(PartitionKey("customer", "a").WithSort("order_id", 2), nil)))
+	assert.Equal(t, calls, stub.calls["TransactWriteItems"])
+}
+
+func TestDynamoTransactVersion(t *testing.T) {
+	ctx := context.Background()
+	stub := newFakeDynamo(t)
+	stub.createTable("accounts", "id", "")
+	accounts := newFakeDynamoTable[testAccount](t, stub, "accounts")
+	accounts.OptimisticLocking = true
+
+	a := &testAccount{Id: "a", Balance: 10}
+	b := &testAccount{Id: "b", Balance: 20}
+	assert.Nil(t, Transact(ctx, accounts.TransactPut(a, SaveItemOptions{}), accounts.TransactPut(b, SaveItemOptions{})))
+	assert.Equal(t, int64(1), a.Version)
+	assert.Equal(t, int64(1), b.Version)
+
+	// A stale copy fails the transaction and keeps the versions
+	stale := *b
+	b.Balance = 25
+	assert.Nil(t, accounts.Save(b))
+	a.Balance = 0
+	stale.Balance = 30
+	err := Transact(ctx, accounts.TransactPut(a, SaveItemOptions{}), accounts.TransactPut(&stale, SaveItemOptions{}))
+	assert.True(t, errors.Is(err, ErrConditionFailed))
+	assert.Equal(t, int64(1), a.Version)
+	assert.Equal(t, int64(1), stale.Version)
+
+	stored, err := accounts.Get(ctx, PartitionKey("id", "a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 10, stored.Balance)
+	assert.Equal(t, int64(1), stored.Version)
+
+	// Updates increment the version
+	assert.Nil(t, Transact(ctx, accounts.TransactUpdate(PartitionKey("id", "a"), UpdateSet("balance", 5))))
+	stored, err = accounts.Get(ctx, PartitionKey("id", "a"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), stored.Version)
+}
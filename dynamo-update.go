@@ -0,0 +1,119 @@
+package cloudyaws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// UpdateOp is one change made to an item by Update
+type UpdateOp func(update expression.UpdateBuilder) expression.UpdateBuilder
+
+// UpdateSet sets an attribute to a value
+func UpdateSet(name string, value interface{}) UpdateOp {
+	return func(update expression.UpdateBuilder) expression.UpdateBuilder {
+		return update.Set(expression.Name(name), expression.Value(value))
+	}
+}
+
+// UpdateRemove removes an attribute
+func UpdateRemove(name string) UpdateOp {
+	return func(update expression.UpdateBuilder) expression.UpdateBuilder {
+		return update.Remove(expression.Name(name))
+	}
+}
+
+// UpdateAdd adds to a number attribute, starting from 0 when it does not exist, so
+// concurrent updates are not lost. Negative values subtract.
+func UpdateAdd(name string, value interface{}) UpdateOp {
+	return func(update expression.UpdateBuilder) expression.UpdateBuilder {
+		return update.Add(expression.Name(name), expression.Value(value))
+	}
+}
+
+// UpdateAppend appends values to a list attribute, starting from an empty list when it does
+// not exist. A NULL attribute, which is what a nil slice is saved as unless the field is
+// omitempty, cannot be appended to.
+func UpdateAppend(name string, values ...interface{}) UpdateOp {
+	return func(update expression.UpdateBuilder) expression.UpdateBuilder {
+		list := expression.Name(name)
+		return update.Set(list, expression.ListAppend(
+			expression.IfNotExists(list, expression.Value([]interface{}{})),
+			expression.Value(values)))
+	}
+}
+
+// Update changes the attributes of an existing item and returns the item as updated. A
+// missing item is an ErrItemNotFound rather than being created by the update. With
+// OptimisticLocking the version is incremented.
+func (d *Dynamo[T]) Update(ctx context.Context, key Key, ops ...UpdateOp) (*T, error) {
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("update of %v in %v has no changes", key, d.Table)
+	}
+	keyAttrs, err := key.attributes()
+	if err != nil {
+		return nil, err
+	}
+	expr, err := d.buildUpdate(key, ops)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := d.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(d.Table),
+		Key:                       keyAttrs,
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ReturnValues:              types.ReturnValueAllNew,
+	})
+	var condErr *types.ConditionalCheckFailedException
+	if errors.As(err, &condErr) {
+		return nil, &DynamoError{Op: "UpdateItem", Table: d.Table, Key: key.String(), Kind: ErrItemNotFound, Err: err}
+	}
+	if err != nil {
+		return nil, mapDynamoError(ctx, "UpdateItem", d.Table, key.String(), err)
+	}
+
+	var out T
+	if err := attributevalue.UnmarshalMap(result.Attributes, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UpdateWith is Update with an update built with the expression package, for changes the
+// UpdateOps do not cover
+func (d *Dynamo[T]) UpdateWith(ctx context.Context, key Key, update expression.UpdateBuilder) (*T, error) {
+	return d.Update(ctx, key, func(expression.UpdateBuilder) expression.UpdateBuilder {
+		return update
+	})
+}
+
+// buildUpdate applies the ops, and the version increment with OptimisticLocking, to an
+// update of an item that must exist
+func (d *Dynamo[T]) buildUpdate(key Key, ops []UpdateOp) (expression.Expression, error) {
+	var update expression.UpdateBuilder
+	for _, op := range ops {
+		update = op(update)
+	}
+	if d.OptimisticLocking {
+		version, err := findVersion(new(T))
+		if err != nil {
+			return expression.Expression{}, err
+		}
+		update = update.Add(expression.Name(version.attribute), expression.Value(1))
+	}
+
+	return expression.NewBuilder().
+		WithUpdate(update).
+		WithCondition(expression.AttributeExists(expression.Name(key.PartitionName))).
+		Build()
+}
@@ -0,0 +1,104 @@
+package cloudyaws
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCounter struct {
+	Id     string   `dynamodbav:"id"`
+	Count  int      `dynamodbav:"count"`
+	Note   string   `dynamodbav:"note,omitempty"`
+	Events []string `dynamodbav:"events,omitempty"` // A nil slice would be stored as NULL, which cannot be appended to
+}
+
+func TestDynamoUpdate(t *testing.T) {
+	ctx := context.Background()
+	stub := newFakeDynamo(t)
+	stub.createTable("orders", "customer", "order_id")
+	orders := newFakeDynamoTable[testOrder](t, stub, "orders")
+	assert.Nil(t, orders.Save(&testOrder{Customer: "c1", OrderId: 1, Status: "open", Total: 10}))
+
+	key := PartitionKey("customer", "c1").WithSort("order_id", 1)
+	order, err := orders.Update(ctx, key, UpdateSet("status", "shipped"), UpdateSet("total", 12.5))
+	assert.Nil(t, err)
+	assert.Equal(t, &testOrder{Customer: "c1", OrderId: 1, Status: "shipped", Total: 12.5}, order)
+
+	order, err = orders.Get(ctx, key)
+	assert.Nil(t, err)
+	assert.Equal(t, "shipped", order.Status)
+
+	// Updates do not create items
+	_, err = orders.Update(ctx, PartitionKey("customer", "c2").WithSort("order_id", 1), UpdateSet("status", "open"))
+	assert.True(t, errors.Is(err, ErrItemNotFound))
+	assert.Len(t, stub.tables["orders"].Items, 1)
+
+	_, err = orders.Update(ctx, key)
+	assert.NotNil(t, err)
+}
+
+func TestDynamoUpdateWith(t *testing.T) {
+	ctx := context.Background()
+	stub := newFakeDynamo(t)
+	stub.createTable("orders", "customer", "order_id")
+	orders := newFakeDynamoTable[testOrder](t, stub, "orders")
+	assert.Nil(t, orders.Save(&testOrder{Customer: "c1", OrderId: 1, Status: "open", Total: 10}))
+
+	key := PartitionKey("customer", "c1").WithSort("order_id", 1)
+	order, err := orders.UpdateWith(ctx, key, expression.Set(expression.Name("status"), expression.Value("shipped")).
+		Set(expression.Name("total"), expression.Value(12.5)))
+	assert.Nil(t, err)
+	assert.Equal(t, &testOrder{Customer: "c1", OrderId: 1, Status: "shipped", Total: 12.5}, order)
+
+	// Updates do not create items
+	_, err = orders.UpdateWith(ctx, PartitionKey("customer", "c2").WithSort("order_id", 1), expression.Set(expression.Name("status"), expression.Value("open")))
+	assert.True(t, errors.Is(err, ErrItemNotFound))
+	assert.Len(t, stub.tables["orders"].Items, 1)
+}
+
+func TestDynamoUpdateOps(t *testing.T) {
+	ctx := context.Background()
+	stub := newFakeDynamo(t)
+	stub.createTable("counters", "id", "")
+	counters := newFakeDynamoTable[testCounter](t, stub, "counters")
+	assert.Nil(t, counters.Save(&testCounter{Id: "c", Note: "new"}))
+	key := PartitionKey("id", "c")
+
+	counter, err := counters.Update(ctx, key, UpdateAdd("count", 5), UpdateAppend("events", "created", "counted"), UpdateRemove("note"))
+	assert.Nil(t, err)
+	assert.Equal(t, &testCounter{Id: "c", Count: 5, Events: []string{"created", "counted"}}, counter)
+
+	counter, err = counters.Update(ctx, key, UpdateAdd("count", -2), UpdateAppend("events", "decremented"), UpdateSet("note", "busy"))
+	assert.Nil(t, err)
+	assert.Equal(t, &testCounter{Id: "c", Count: 3, Note: "busy", Events: []string{"created", "counted", "decremented"}}, counter)
+
+	// Attribute names that are reserved words are fine, names are always placeholders
+	_, err = counters.Update(ctx, key, UpdateAdd("count", 1))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"N": "4"}, stub.tables["counters"].Items[`{"S":"c"}`]["count"])
+}
+
+func TestDynamoUpdateVersion(t *testing.T) {
+	ctx := context.Background()
+	stub := newFakeDynamo(t)
+	stub.createTable("accounts", "id", "")
+	accounts := newFakeDynamoTable[testAccount](t, stub, "accounts")
+	accounts.OptimisticLocking = true
+
+	account := &testAccount{Id: "a1", Balance: 100}
+	assert.Nil(t, accounts.Save(account))
+
+	updated, err := accounts.Update(ctx, PartitionKey("id", "a1"), UpdateAdd("balance", 25))
+	assert.Nil(t, err)
+	assert.Equal(t, &testAccount{Id: "a1", Balance: 125, Version: 2}, updated)
+
+	// Copies read before the update are out of date
+	account.Balance = 0
+	assert.True(t, errors.Is(accounts.Save(account), ErrConditionFailed))
+	assert.Nil(t, accounts.Save(updated))
+	assert.Equal(t, int64(3), updated.Version)
+}
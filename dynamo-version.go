@@ -0,0 +1,65 @@
+package cloudyaws
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+)
+
+// VersionTag marks the integer field Dynamo uses for optimistic locking, `dynamo:"version"`
+const VersionTag = "version"
+
+// itemVersion is the version field of an item being saved
+type itemVersion struct {
+	field     reflect.Value
+	attribute string
+	previous  int64
+}
+
+// findVersion finds the field of item tagged `dynamo:"version"`
+func findVersion(item interface{}) (*itemVersion, error) {
+	v := reflect.ValueOf(item)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("optimistic locking needs a pointer to a struct, not %T", item)
+	}
+	v = v.Elem()
+
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.Tag.Get("dynamo") != VersionTag {
+			continue
+		}
+		switch field.Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		default:
+			return nil, fmt.Errorf("version field %v of %T must be a signed integer, not %v", field.Name, item, field.Type)
+		}
+
+		attribute, _, _ := strings.Cut(field.Tag.Get("dynamodbav"), ",")
+		if attribute == "" {
+			attribute = field.Name
+		}
+		return &itemVersion{field: v.Field(i), attribute: attribute, previous: v.Field(i).Int()}, nil
+	}
+	return nil, fmt.Errorf("optimistic locking needs a field of %T tagged `dynamo:\"%v\"`", item, VersionTag)
+}
+
+// condition is what the stored item must match: version 0 is an item that was never saved
+func (v *itemVersion) condition() expression.ConditionBuilder {
+	name := expression.Name(v.attribute)
+	if v.previous == 0 {
+		return expression.AttributeNotExists(name)
+	}
+	return name.Equal(expression.Value(v.previous))
+}
+
+func (v *itemVersion) increment() {
+	v.field.SetInt(v.previous + 1)
+}
+
+// restore puts back the version of a save that failed
+func (v *itemVersion) restore() {
+	v.field.SetInt(v.previous)
+}
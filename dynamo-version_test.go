@@ -0,0 +1,90 @@
+package cloudyaws
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/stretchr/testify/assert"
+)
+
+type testAccount struct {
+	Id      string `dynamodbav:"id"`
+	Balance int    `dynamodbav:"balance"`
+	Version int64  `dynamodbav:"ver" dynamo:"version"`
+}
+
+func TestDynamoConditionalSave(t *testing.T) {
+	ctx := context.Background()
+	stub := newFakeDynamo(t)
+	stub.createTable("orders", "customer", "order_id")
+	orders := newFakeDynamoTable[testOrder](t, stub, "orders")
+
+	order := &testOrder{Customer: "c1", OrderId: 1, Status: "open", Total: 10}
+	assert.Nil(t, orders.SaveWithOptions(ctx, order, SaveItemOptions{Condition: ItemNotExists("customer")}))
+
+	err := orders.SaveWithOptions(ctx, &testOrder{Customer: "c1", OrderId: 1, Status: "new"}, SaveItemOptions{Condition: ItemNotExists("customer")})
+	assert.True(t, errors.Is(err, ErrConditionFailed))
+	var dynamoErr *DynamoError
+	assert.True(t, errors.As(err, &dynamoErr))
+	assert.Equal(t, "PutItem", dynamoErr.Op)
+
+	// Custom condition on the stored item
+	isOpen := expression.Name("status").Equal(expression.Value("open"))
+	order.Status = "shipped"
+	assert.Nil(t, orders.SaveWithOptions(ctx, order, SaveItemOptions{Condition: &isOpen}))
+	order.Status = "cancelled"
+	err = orders.SaveWithOptions(ctx, order, SaveItemOptions{Condition: &isOpen})
+	assert.True(t, errors.Is(err, ErrConditionFailed))
+
+	stored, err := orders.Get(ctx, PartitionKey("customer", "c1").WithSort("order_id", 1))
+	assert.Nil(t, err)
+	assert.Equal(t, "shipped", stored.Status)
+}
+
+func TestDynamoOptimisticLocking(t *testing.T) {
+	ctx := context.Background()
+	stub := newFakeDynamo(t)
+	stub.createTable("accounts", "id", "")
+	accounts := newFakeDynamoTable[testAccount](t, stub, "accounts")
+	accounts.OptimisticLocking = true
+
+	account := &testAccount{Id: "a1", Balance: 100}
+	assert.Nil(t, accounts.Save(account))
+	assert.Equal(t, int64(1), account.Version)
+
+	// A second new item with the same key conflicts
+	err := accounts.Save(&testAccount{Id: "a1", Balance: 5})
+	assert.True(t, errors.Is(err, ErrConditionFailed))
+
+	first, err := accounts.Get(ctx, PartitionKey("id", "a1"))
+	assert.Nil(t, err)
+	second, err := accounts.Get(ctx, PartitionKey("id", "a1"))
+	assert.Nil(t, err)
+
+	first.Balance += 50
+	assert.Nil(t, accounts.SaveWithOptions(ctx, first, SaveItemOptions{}))
+	assert.Equal(t, int64(2), first.Version)
+
+	// The second copy is out of date, its version is left as it was
+	second.Balance -= 30
+	err = accounts.SaveWithOptions(ctx, second, SaveItemOptions{})
+	assert.True(t, errors.Is(err, ErrConditionFailed))
+	assert.Equal(t, int64(1), second.Version)
+
+	stored, err := accounts.Get(ctx, PartitionKey("id", "a1"))
+	assert.Nil(t, err)
+	assert.Equal(t, &testAccount{Id: "a1", Balance: 150, Version: 2}, stored)
+
+	// Conditions combine with the version check
+	rich := expression.Name("balance").GreaterThanEqual(expression.Value(1000))
+	err = accounts.SaveWithOptions(ctx, stored, SaveItemOptions{Condition: &rich})
+	assert.True(t, errors.Is(err, ErrConditionFailed))
+	assert.Equal(t, int64(2), stored.Version)
+
+	// T needs a version field
+	orders := newFakeDynamoTable[testOrder](t, stub, "accounts")
+	orders.OptimisticLocking = true
+	assert.NotNil(t, orders.Save(&testOrder{Customer: "c1"}))
+}
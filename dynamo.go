@@ -1,45 +1,95 @@
 package cloudyaws
 
 import (
-	"errors"
+	"context"
+	"sync"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
-
-	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 )
 
 type Dynamo[T any] struct {
-	Sess   *session.Session
-	Client *dynamodb.DynamoDB
+	Client *dynamodb.Client
 	Table  string
+
+	// Saves check and increment the field of T tagged `dynamo:"version"`, a save based on
+	// an out of date copy of the item fails with ErrConditionFailed
+	OptimisticLocking bool
+
+	mu        sync.Mutex
+	keySchema []string // Key attribute names, read by keyNames
 }
 
-func NewDynamo[T any](tableName string) (*Dynamo[T], error) {
-	// Initialize a session that the SDK will use to load
-	// credentials from the shared credentials file ~/.aws/credentials
-	// and region from the shared configuration file ~/.aws/config.
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
+func NewDynamo[T any](ctx context.Context, creds *AwsCredentials, tableName string) (*Dynamo[T], error) {
+	cfg, err := NewAwsConfig(ctx, creds)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateOnConfigure(ctx, creds, cfg, DynamoActions); err != nil {
+		return nil, err
+	}
 
 	// Create DynamoDB client
-	svc := dynamodb.New(sess)
+	svc := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if url := creds.EndpointFor(dynamodb.ServiceID); url != "" {
+			o.BaseEndpoint = aws.String(url)
+		}
+	})
 
 	return &Dynamo[T]{
-		Sess:   sess,
 		Client: svc,
 		Table:  tableName,
 	}, nil
 }
 
+// SaveItemOptions are the optional parts of SaveWithOptions
+type SaveItemOptions struct {
+	// The existing item, if any, must meet the condition or the save fails with
+	// ErrConditionFailed. Use ItemNotExists to only create items.
+	Condition *expression.ConditionBuilder
+}
+
+// ItemNotExists is the condition of a save that only creates items, partitionKey is the name
+// of the partition key of the table
+func ItemNotExists(partitionKey string) *expression.ConditionBuilder {
+	cond := expression.AttributeNotExists(expression.Name(partitionKey))
+	return &cond
+}
+
 func (d *Dynamo[T]) Save(item *T) error {
-	// Load the item
-	itemMap, err := dynamodbattribute.MarshalMap(item)
+	return d.SaveWithOptions(context.Background(), item, SaveItemOptions{})
+}
+
+// SaveWithOptions creates or replaces an item. With OptimisticLocking the version of item is
+// incremented when the save succeeds.
+func (d *Dynamo[T]) SaveWithOptions(ctx context.Context, item *T, opts SaveItemOptions) error {
+	cond := opts.Condition
+	if d.OptimisticLocking {
+		version, err := findVersion(item)
+		if err != nil {
+			return err
+		}
+		versionCond := version.condition()
+		if cond != nil {
+			versionCond = cond.And(versionCond)
+		}
+		cond = &versionCond
+
+		version.increment()
+		err = d.putItem(ctx, item, cond)
+		if err != nil {
+			version.restore()
+		}
+		return err
+	}
+	return d.putItem(ctx, item, cond)
+}
+
+func (d *Dynamo[T]) putItem(ctx context.Context, item *T, cond *expression.ConditionBuilder) error {
+	itemMap, err := attributevalue.MarshalMap(item)
 	if err != nil {
-		fmt.Println("Got error marshalling new movie item:")
 		return err
 	}
 
@@ -47,33 +97,60 @@ func (d *Dynamo[T]) Save(item *T) error {
 		Item:      itemMap,
 		TableName: aws.String(d.Table),
 	}
-
-	_, err = d.Client.PutItem(input)
-	if err != nil {
-		fmt.Println("Got error calling PutItem:")
-		return err
+	if cond != nil {
+		expr, err := expression.NewBuilder().WithCondition(*cond).Build()
+		if err != nil {
+			return err
+		}
+		input.ConditionExpression = expr.Condition()
+		input.ExpressionAttributeNames = expr.Names()
+		input.ExpressionAttributeValues = expr.Values()
 	}
 
-	return nil
+	_, err = d.Client.PutItem(ctx, input)
+	return mapDynamoError(ctx, "PutItem", d.Table, "", err)
 }
 
+// Read gets the item whose string partition key named key is attribute
 func (d *Dynamo[T]) Read(key string, attribute string) (*T, error) {
-	var out T
-	result, err := d.Client.GetItem(&dynamodb.GetItemInput{
+	return d.Get(context.Background(), PartitionKey(key, attribute))
+}
+
+// Get reads an item, a missing item is an ErrItemNotFound
+func (d *Dynamo[T]) Get(ctx context.Context, key Key) (*T, error) {
+	keyAttrs, err := key.attributes()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := d.Client.GetItem(ctx, &dynamodb.GetItemInput{
 		TableName: aws.String(d.Table),
-		Key: map[string]*dynamodb.AttributeValue{
-			key: {S: aws.String(attribute)},
-		},
+		Key:       keyAttrs,
 	})
 	if err != nil {
+		return nil, mapDynamoError(ctx, "GetItem", d.Table, key.String(), err)
+	}
+	if len(result.Item) == 0 {
+		return nil, &DynamoError{Op: "GetItem", Table: d.Table, Key: key.String(), Kind: ErrItemNotFound}
+	}
+
+	var out T
+	if err := attributevalue.UnmarshalMap(result.Item, &out); err != nil {
 		return nil, err
 	}
+	return &out, nil
+}
 
-	if result.Item == nil {
-		msg := "Could not find '" + attribute + "'"
-		return nil, errors.New(msg)
+// Delete removes an item, deleting an item that does not exist is not an error
+func (d *Dynamo[T]) Delete(ctx context.Context, key Key) error {
+	keyAttrs, err := key.attributes()
+	if err != nil {
+		return err
 	}
 
-	err = dynamodbattribute.UnmarshalMap(result.Item, &out)
-	return &out, err
+	_, err = d.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.Table),
+		Key:       keyAttrs,
+	})
+	return mapDynamoError(ctx, "DeleteItem", d.Table, key.String(), err)
 }
@@ -0,0 +1,773 @@
+package cloudyaws
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDynamo is an in-memory DynamoDB speaking the JSON protocol of the SDK. It understands
+// just enough of the expression syntax the expression package generates for the tests.
+type fakeDynamo struct {
+	*httptest.Server
+	mu     sync.Mutex
+	tables map[string]*fakeTable
+	fail   map[string]string // Operation to the error code its next call fails with
+	calls  map[string]int
+
+	// Batch operation to how many of its next calls process only the first request
+	unprocessed map[string]int
+}
+
+type fakeTable struct {
+	Partition string
+	Sort      string
+	Indexes   map[string]fakeIndex
+	Items     map[string]fakeItem
+}
+
+type fakeIndex struct {
+	Partition string
+	Sort      string
+}
+
+// fakeItem is an item in the wire format, e.g. {"id": {"S": "a"}}
+type fakeItem map[string]interface{}
+
+type fakeDynamoError struct {
+	Code    string
+	Message string
+}
+
+func (e *fakeDynamoError) Error() string {
+	return e.Message
+}
+
+// fakeCancelled is a TransactionCanceledException, with a reason code for each item
+type fakeCancelled struct {
+	Reasons []string
+}
+
+func (e *fakeCancelled) Error() string {
+	return fmt.Sprintf("Transaction cancelled, please refer cancellation reasons for specific reasons %v", e.Reasons)
+}
+
+func newFakeDynamo(t *testing.T) *fakeDynamo {
+	stub := &fakeDynamo{tables: map[string]*fakeTable{}, fail: map[string]string{}, calls: map[string]int{}, unprocessed: map[string]int{}}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&input)
+
+		handlers := map[string]func(map[string]interface{}) (interface{}, error){
+			"GetItem":    stub.getItem,
+			"PutItem":    stub.putItem,
+			"DeleteItem": stub.deleteItem,
+			"UpdateItem": stub.updateItem,
+			"Query":      stub.query,
+			"Scan":       stub.scan,
+
+			"DescribeTable":      stub.describeTable,
+			"BatchWriteItem":     stub.batchWriteItem,
+			"BatchGetItem":       stub.batchGetItem,
+			"TransactWriteItems": stub.transactWriteItems,
+		}
+		op := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		stub.calls[op]++
+		handler, ok := handlers[op]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if code, ok := stub.fail[op]; ok {
+			delete(stub.fail, op)
+			handler = func(map[string]interface{}) (interface{}, error) {
+				return nil, &fakeDynamoError{code, "injected failure"}
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		output, err := handler(input)
+		if err != nil {
+			body := map[string]interface{}{"__type": "com.amazonaws.dynamodb.v20120810#InternalServerError", "message": err.Error()}
+			if derr, ok := err.(*fakeDynamoError); ok {
+				body["__type"] = "com.amazonaws.dynamodb.v20120810#" + derr.Code
+			}
+			if cerr, ok := err.(*fakeCancelled); ok {
+				body["__type"] = "com.amazonaws.dynamodb.v20120810#TransactionCanceledException"
+				reasons := []map[string]string{}
+				for _, code := range cerr.Reasons {
+					reasons = append(reasons, map[string]string{"Code": code})
+				}
+				body["CancellationReasons"] = reasons
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(body)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(output)
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func (stub *fakeDynamo) createTable(name string, partition string, sort string) *fakeTable {
+	table := &fakeTable{Partition: partition, Sort: sort, Indexes: map[string]fakeIndex{}, Items: map[string]fakeItem{}}
+	stub.tables[name] = table
+	return table
+}
+
+func (stub *fakeDynamo) table(input map[string]interface{}) (*fakeTable, error) {
+	table, ok := stub.tables[input["TableName"].(string)]
+	if !ok {
+		return nil, &fakeDynamoError{"ResourceNotFoundException", "Requested resource not found"}
+	}
+	return table, nil
+}
+
+// itemKey is the identity of an item in a table, from its key attributes
+func (table *fakeTable) itemKey(item map[string]interface{}) (string, error) {
+	partition, ok := item[table.Partition]
+	if !ok {
+		return "", &fakeDynamoError{"ValidationException", "The provided key element does not match the schema"}
+	}
+	key, _ := json.Marshal(partition)
+	if table.Sort != "" {
+		sort, ok := item[table.Sort]
+		if !ok {
+			return "", &fakeDynamoError{"ValidationException", "The provided key element does not match the schema"}
+		}
+		sortKey, _ := json.Marshal(sort)
+		key = append(append(key, '|'), sortKey...)
+	}
+	return string(key), nil
+}
+
+func (stub *fakeDynamo) getItem(input map[string]interface{}) (interface{}, error) {
+	table, err := stub.table(input)
+	if err != nil {
+		return nil, err
+	}
+	key, err := table.itemKey(input["Key"].(map[string]interface{}))
+	if err != nil {
+		return nil, err
+	}
+	if item, ok := table.Items[key]; ok {
+		return map[string]interface{}{"Item": item}, nil
+	}
+	return map[string]interface{}{}, nil
+}
+
+func (stub *fakeDynamo) putItem(input map[string]interface{}) (interface{}, error) {
+	table, err := stub.table(input)
+	if err != nil {
+		return nil, err
+	}
+	item := fakeItem(input["Item"].(map[string]interface{}))
+	key, err := table.itemKey(item)
+	if err != nil {
+		return nil, err
+	}
+	names, _ := input["ExpressionAttributeNames"].(map[string]interface{})
+	values, _ := input["ExpressionAttributeValues"].(map[string]interface{})
+	if cond, ok := input["ConditionExpression"].(string); ok && !fakeCondition(cond, names, values, table.Items[key]) {
+		return nil, &fakeDynamoError{"ConditionalCheckFailedException", "The conditional request failed"}
+	}
+	table.Items[key] = item
+	return map[string]interface{}{}, nil
+}
+
+func (stub *fakeDynamo) deleteItem(input map[string]interface{}) (interface{}, error) {
+	table, err := stub.table(input)
+	if err != nil {
+		return nil, err
+	}
+	key, err := table.itemKey(input["Key"].(map[string]interface{}))
+	if err != nil {
+		return nil, err
+	}
+	delete(table.Items, key)
+	return map[string]interface{}{}, nil
+}
+
+func (stub *fakeDynamo) updateItem(input map[string]interface{}) (interface{}, error) {
+	table, err := stub.table(input)
+	if err != nil {
+		return nil, err
+	}
+	keyAttrs := input["Key"].(map[string]interface{})
+	key, err := table.itemKey(keyAttrs)
+	if err != nil {
+		return nil, err
+	}
+	names, _ := input["ExpressionAttributeNames"].(map[string]interface{})
+	values, _ := input["ExpressionAttributeValues"].(map[string]interface{})
+
+	existing, exists := table.Items[key]
+	if cond, ok := input["ConditionExpression"].(string); ok && !fakeCondition(cond, names, values, existing) {
+		return nil, &fakeDynamoError{"ConditionalCheckFailedException", "The conditional request failed"}
+	}
+
+	item := fakeItem{}
+	for k, v := range keyAttrs {
+		item[k] = v
+	}
+	if exists {
+		for k, v := range existing {
+			item[k] = v
+		}
+	}
+	if err := fakeUpdate(input["UpdateExpression"].(string), names, values, item); err != nil {
+		return nil, err
+	}
+	table.Items[key] = item
+
+	if input["ReturnValues"] == "ALL_NEW" {
+		return map[string]interface{}{"Attributes": item}, nil
+	}
+	return map[string]interface{}{}, nil
+}
+
+func (stub *fakeDynamo) describeTable(input map[string]interface{}) (interface{}, error) {
+	table, err := stub.table(input)
+	if err != nil {
+		return nil, err
+	}
+	schema := []map[string]string{{"AttributeName": table.Partition, "KeyType": "HASH"}}
+	if table.Sort != "" {
+		schema = append(schema, map[string]string{"AttributeName": table.Sort, "KeyType": "RANGE"})
+	}
+	return map[string]interface{}{"Table": map[string]interface{}{"TableName": input["TableName"], "KeySchema": schema}}, nil
+}
+
+// batchWriteItem puts and deletes, leaving all but the first request of each table
+// unprocessed while stub.unprocessed says so
+func (stub *fakeDynamo) batchWriteItem(input map[string]interface{}) (interface{}, error) {
+	partial := stub.unprocessed["BatchWriteItem"] > 0
+	if partial {
+		stub.unprocessed["BatchWriteItem"]--
+	}
+
+	unprocessed := map[string]interface{}{}
+	for name, requests := range input["RequestItems"].(map[string]interface{}) {
+		requests := requests.([]interface{})
+		if len(requests) > 25 {
+			return nil, &fakeDynamoError{"ValidationException", "Too many items requested for the BatchWriteItem call"}
+		}
+		table, err := stub.table(map[string]interface{}{"TableName": name})
+		if err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		for _, request := range requests {
+			request := request.(map[string]interface{})
+			var keyAttrs map[string]interface{}
+			if put, ok := request["PutRequest"].(map[string]interface{}); ok {
+				keyAttrs = put["Item"].(map[string]interface{})
+			} else {
+				keyAttrs = request["DeleteRequest"].(map[string]interface{})["Key"].(map[string]interface{})
+			}
+			key, err := table.itemKey(keyAttrs)
+			if err != nil {
+				return nil, err
+			}
+			if seen[key] {
+				return nil, &fakeDynamoError{"ValidationException", "Provided list of item keys contains duplicates"}
+			}
+			seen[key] = true
+		}
+		for i, request := range requests {
+			if partial && i > 0 {
+				unprocessed[name] = requests[i:]
+				break
+			}
+			request := request.(map[string]interface{})
+			var err error
+			if put, ok := request["PutRequest"].(map[string]interface{}); ok {
+				_, err = stub.putItem(map[string]interface{}{"TableName": name, "Item": put["Item"]})
+			} else {
+				del := request["DeleteRequest"].(map[string]interface{})
+				_, err = stub.deleteItem(map[string]interface{}{"TableName": name, "Key": del["Key"]})
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return map[string]interface{}{"UnprocessedItems": unprocessed}, nil
+}
+
+// batchGetItem reads, leaving all but the first key of each table unprocessed while
+// stub.unprocessed says so
+func (stub *fakeDynamo) batchGetItem(input map[string]interface{}) (interface{}, error) {
+	partial := stub.unprocessed["BatchGetItem"] > 0
+	if partial {
+		stub.unprocessed["BatchGetItem"]--
+	}
+
+	responses := map[string]interface{}{}
+	unprocessed := map[string]interface{}{}
+	for name, request := range input["RequestItems"].(map[string]interface{}) {
+		keys := request.(map[string]interface{})["Keys"].([]interface{})
+		if len(keys) > 100 {
+			return nil, &fakeDynamoError{"ValidationException", "Too many items requested for the BatchGetItem call"}
+		}
+		seen := map[string]bool{}
+		items := []interface{}{}
+		for i, key := range keys {
+			id, _ := json.Marshal(key)
+			if seen[string(id)] {
+				return nil, &fakeDynamoError{"ValidationException", "Provided list of item keys contains duplicates"}
+			}
+			seen[string(id)] = true
+			if partial && i > 0 {
+				unprocessed[name] = map[string]interface{}{"Keys": keys[i:]}
+				break
+			}
+			output, err := stub.getItem(map[string]interface{}{"TableName": name, "Key": key})
+			if err != nil {
+				return nil, err
+			}
+			if item, ok := output.(map[string]interface{})["Item"]; ok {
+				items = append(items, item)
+			}
+		}
+		responses[name] = items
+	}
+	return map[string]interface{}{"Responses": responses, "UnprocessedKeys": unprocessed}, nil
+}
+
+// transactWriteItems checks the condition of every item before applying any of them
+func (stub *fakeDynamo) transactWriteItems(input map[string]interface{}) (interface{}, error) {
+	type write struct {
+		op    string
+		input map[string]interface{}
+	}
+	var writes []write
+	reasons := []string{}
+	failed := false
+	for _, item := range input["TransactItems"].([]interface{}) {
+		for op, request := range item.(map[string]interface{}) {
+			request := request.(map[string]interface{})
+			table, err := stub.table(request)
+			if err != nil {
+				return nil, err
+			}
+			keyAttrs, _ := request["Key"].(map[string]interface{})
+			if op == "Put" {
+				keyAttrs = request["Item"].(map[string]interface{})
+			}
+			key, err := table.itemKey(keyAttrs)
+			if err != nil {
+				return nil, err
+			}
+
+			names, _ := request["ExpressionAttributeNames"].(map[string]interface{})
+			values, _ := request["ExpressionAttributeValues"].(map[string]interface{})
+			cond, ok := request["ConditionExpression"].(string)
+			if ok && !fakeCondition(cond, names, values, table.Items[key]) {
+				reasons = append(reasons, "ConditionalCheckFailed")
+				failed = true
+			} else {
+				reasons = append(reasons, "None")
+			}
+			delete(request, "ConditionExpression")
+			writes = append(writes, write{op, request})
+		}
+	}
+	if failed {
+		return nil, &fakeCancelled{Reasons: reasons}
+	}
+
+	for _, w := range writes {
+		var err error
+		switch w.op {
+		case "Put":
+			_, err = stub.putItem(w.input)
+		case "Update":
+			_, err = stub.updateItem(w.input)
+		case "Delete":
+			_, err = stub.deleteItem(w.input)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{}, nil
+}
+
+var (
+	fakeBetween    = regexp.MustCompile(`^(#\w+) BETWEEN (:\w+) AND (:\w+)$`)
+	fakeComparison = regexp.MustCompile(`^(#\w+) (=|<>|<=|<|>=|>) (:\w+)$`)
+	fakeFunction   = regexp.MustCompile(`^(\w+) \((#\w+)(?:, (:\w+))?\)$`)
+)
+
+// fakeCondition evaluates the conditions the expression package generates: ANDs of
+// comparisons, BETWEEN, begins_with, attribute_exists and attribute_not_exists
+func fakeCondition(cond string, names map[string]interface{}, values map[string]interface{}, item fakeItem) bool {
+	cond = strings.TrimSpace(cond)
+	attr := func(name string) (interface{}, bool) {
+		v, ok := item[names[name].(string)]
+		return v, ok
+	}
+
+	if m := fakeBetween.FindStringSubmatch(cond); m != nil {
+		v, ok := attr(m[1])
+		return ok && fakeCompare(v, values[m[2]]) >= 0 && fakeCompare(v, values[m[3]]) <= 0
+	}
+	if parts := fakeSplitAnd(cond); len(parts) > 1 {
+		for _, part := range parts {
+			if !fakeCondition(part, names, values, item) {
+				return false
+			}
+		}
+		return true
+	}
+	if strings.HasPrefix(cond, "(") && strings.HasSuffix(cond, ")") {
+		return fakeCondition(cond[1:len(cond)-1], names, values, item)
+	}
+	if m := fakeComparison.FindStringSubmatch(cond); m != nil {
+		v, ok := attr(m[1])
+		if !ok {
+			return m[2] == "<>"
+		}
+		c := fakeCompare(v, values[m[3]])
+		switch m[2] {
+		case "=":
+			return c == 0
+		case "<>":
+			return c != 0
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		case ">=":
+			return c >= 0
+		}
+	}
+	if m := fakeFunction.FindStringSubmatch(cond); m != nil {
+		v, exists := attr(m[2])
+		switch m[1] {
+		case "attribute_exists":
+			return exists
+		case "attribute_not_exists":
+			return !exists
+		case "begins_with":
+			s, _ := v.(map[string]interface{})["S"].(string)
+			prefix := values[m[3]].(map[string]interface{})["S"].(string)
+			return exists && strings.HasPrefix(s, prefix)
+		}
+	}
+	panic("unsupported condition: " + cond)
+}
+
+// fakeSplitAnd splits a condition at the ANDs outside parentheses
+func fakeSplitAnd(cond string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(cond); i++ {
+		switch cond[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if depth == 0 && strings.HasPrefix(cond[i:], " AND ") {
+			parts = append(parts, cond[start:i])
+			i += len(" AND ") - 1
+			start = i + 1
+		}
+	}
+	return append(parts, cond[start:])
+}
+
+// fakeCompare orders two S or N attribute values
+func fakeCompare(a interface{}, b interface{}) int {
+	av, bv := a.(map[string]interface{}), b.(map[string]interface{})
+	if an, ok := av["N"].(string); ok {
+		x, _ := strconv.ParseFloat(an, 64)
+		y, _ := strconv.ParseFloat(bv["N"].(string), 64)
+		return cmp.Compare(x, y)
+	}
+	return cmp.Compare(fmt.Sprint(av["S"]), fmt.Sprint(bv["S"]))
+}
+
+// query returns the items of the key condition in sort key order, a page at a time
+func (stub *fakeDynamo) query(input map[string]interface{}) (interface{}, error) {
+	table, err := stub.table(input)
+	if err != nil {
+		return nil, err
+	}
+	names, _ := input["ExpressionAttributeNames"].(map[string]interface{})
+	values, _ := input["ExpressionAttributeValues"].(map[string]interface{})
+	schema := fakeIndex{table.Partition, table.Sort}
+	if index, ok := input["IndexName"].(string); ok {
+		if schema, ok = table.Indexes[index]; !ok {
+			return nil, &fakeDynamoError{"ValidationException", "The table does not have the specified index"}
+		}
+	}
+
+	var items []fakeItem
+	for _, item := range table.sortedItems() {
+		if _, ok := item[schema.Partition]; !ok {
+			continue
+		}
+		if fakeCondition(input["KeyConditionExpression"].(string), names, values, item) {
+			items = append(items, item)
+		}
+	}
+	if schema.Sort != "" {
+		sort.SliceStable(items, func(i, j int) bool {
+			return fakeCompare(items[i][schema.Sort], items[j][schema.Sort]) < 0
+		})
+	}
+	if forward, ok := input["ScanIndexForward"].(bool); ok && !forward {
+		slices.Reverse(items)
+	}
+	return table.page(input, items, schema, names, values)
+}
+
+// scan returns the items of a segment in a stable order, a page at a time
+func (stub *fakeDynamo) scan(input map[string]interface{}) (interface{}, error) {
+	table, err := stub.table(input)
+	if err != nil {
+		return nil, err
+	}
+	names, _ := input["ExpressionAttributeNames"].(map[string]interface{})
+	values, _ := input["ExpressionAttributeValues"].(map[string]interface{})
+	segment, _ := input["Segment"].(float64)
+	total, _ := input["TotalSegments"].(float64)
+
+	var items []fakeItem
+	for _, item := range table.sortedItems() {
+		key, _ := table.itemKey(item)
+		h := fnv.New32()
+		h.Write([]byte(key))
+		if total == 0 || h.Sum32()%uint32(total) == uint32(segment) {
+			items = append(items, item)
+		}
+	}
+	return table.page(input, items, fakeIndex{}, names, values)
+}
+
+func (table *fakeTable) sortedItems() []fakeItem {
+	keys := make([]string, 0, len(table.Items))
+	for key := range table.Items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	items := make([]fakeItem, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, table.Items[key])
+	}
+	return items
+}
+
+// page applies ExclusiveStartKey, Limit and the filter like DynamoDB: Limit counts the items
+// read and the filter only removes items from the page
+func (table *fakeTable) page(input map[string]interface{}, items []fakeItem, index fakeIndex, names map[string]interface{}, values map[string]interface{}) (interface{}, error) {
+	if start, ok := input["ExclusiveStartKey"].(map[string]interface{}); ok {
+		startKey, _ := table.itemKey(start)
+		for i, item := range items {
+			if key, _ := table.itemKey(item); key == startKey {
+				items = items[i+1:]
+				break
+			}
+		}
+	}
+
+	output := map[string]interface{}{}
+	if limit, ok := input["Limit"].(float64); ok && int(limit) < len(items) {
+		items = items[:int(limit)]
+		last := items[len(items)-1]
+		lastKey := map[string]interface{}{}
+		for _, name := range []string{table.Partition, table.Sort, index.Partition, index.Sort} {
+			if name != "" {
+				lastKey[name] = last[name]
+			}
+		}
+		output["LastEvaluatedKey"] = lastKey
+	}
+
+	page := []fakeItem{}
+	for _, item := range items {
+		if filter, ok := input["FilterExpression"].(string); !ok || fakeCondition(filter, names, values, item) {
+			page = append(page, item)
+		}
+	}
+	output["Items"] = page
+	output["Count"] = len(page)
+	return output, nil
+}
+
+var (
+	fakeListAppend = regexp.MustCompile(`^list_append\(if_not_exists\((#\w+), (:\w+)\), (:\w+)\)$`)
+	fakeAssignment = regexp.MustCompile(`(#\w+) = (list_append\(.*?\), :\w+\)|:\w+)`)
+)
+
+// fakeUpdate applies an update expression to an item, one clause a line
+func fakeUpdate(update string, names map[string]interface{}, values map[string]interface{}, item fakeItem) error {
+	for _, clause := range strings.Split(strings.TrimSpace(update), "\n") {
+		action, args, _ := strings.Cut(clause, " ")
+		switch action {
+		case "SET":
+			for _, m := range fakeAssignment.FindAllStringSubmatch(args, -1) {
+				name := names[m[1]].(string)
+				if la := fakeListAppend.FindStringSubmatch(m[2]); la != nil {
+					list, ok := item[name]
+					if !ok {
+						list = values[la[2]]
+					}
+					elems, ok := list.(map[string]interface{})["L"].([]interface{})
+					if !ok {
+						return &fakeDynamoError{"ValidationException", "An operand in the update expression has an incorrect data type"}
+					}
+					joined := append(slices.Clone(elems), values[la[3]].(map[string]interface{})["L"].([]interface{})...)
+					item[name] = map[string]interface{}{"L": joined}
+				} else {
+					item[name] = values[m[2]]
+				}
+			}
+		case "REMOVE":
+			for _, name := range strings.Split(args, ", ") {
+				delete(item, names[name].(string))
+			}
+		case "ADD":
+			for _, pair := range strings.Split(args, ", ") {
+				name, value, _ := strings.Cut(pair, " ")
+				attr := names[name].(string)
+				current := 0.0
+				if existing, ok := item[attr]; ok {
+					n, ok := existing.(map[string]interface{})["N"].(string)
+					if !ok {
+						return &fakeDynamoError{"ValidationException", "An operand in the update expression has an incorrect data type"}
+					}
+					current, _ = strconv.ParseFloat(n, 64)
+				}
+				delta, _ := strconv.ParseFloat(values[value].(map[string]interface{})["N"].(string), 64)
+				item[attr] = map[string]interface{}{"N": strconv.FormatFloat(current+delta, 'f', -1, 64)}
+			}
+		default:
+			panic("unsupported update: " + clause)
+		}
+	}
+	return nil
+}
+
+type testOrder struct {
+	Customer string  `dynamodbav:"customer"`
+	OrderId  int     `dynamodbav:"order_id"`
+	Status   string  `dynamodbav:"status"`
+	Total    float64 `dynamodbav:"total"`
+}
+
+type testDocument struct {
+	Id   string `dynamodbav:"id"`
+	Body string `dynamodbav:"body"`
+}
+
+func newFakeDynamoTable[T any](t *testing.T, stub *fakeDynamo, table string) *Dynamo[T] {
+	isolateAwsEnv(t)
+	creds := &AwsCredentials{
+		Type:            CredTypeSecret,
+		Region:          "us-east-1",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+		MaxAttempts:     1,
+		Endpoints:       map[string]string{"dynamodb": stub.URL},
+	}
+	d, err := NewDynamo[T](context.Background(), creds, table)
+	assert.Nil(t, err)
+	return d
+}
+
+func TestDynamoKeys(t *testing.T) {
+	attrs, err := PartitionKey("id", "a").attributes()
+	assert.Nil(t, err)
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "a"}, attrs["id"])
+
+	attrs, err = PartitionKey("customer", "c1").WithSort("order_id", 42).attributes()
+	assert.Nil(t, err)
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "c1"}, attrs["customer"])
+	assert.Equal(t, &types.AttributeValueMemberN{Value: "42"}, attrs["order_id"])
+
+	attrs, err = PartitionKey("n", json.Number("1.5")).WithSort("b", []byte{1, 2}).attributes()
+	assert.Nil(t, err)
+	assert.Equal(t, &types.AttributeValueMemberN{Value: "1.5"}, attrs["n"])
+	assert.Equal(t, &types.AttributeValueMemberB{Value: []byte{1, 2}}, attrs["b"])
+
+	for _, key := range []Key{
+		{},
+		PartitionKey("id", ""),
+		PartitionKey("id", []byte{}),
+		PartitionKey("id", true),
+		PartitionKey("id", []string{"a"}),
+		PartitionKey("id", "a").WithSort("sk", nil),
+	} {
+		_, err := key.attributes()
+		assert.True(t, errors.Is(err, ErrInvalidKey), key.String())
+	}
+
+	assert.Equal(t, "customer=c1, order_id=42", PartitionKey("customer", "c1").WithSort("order_id", 42).String())
+}
+
+func TestDynamoGetDelete(t *testing.T) {
+	ctx := context.Background()
+	stub := newFakeDynamo(t)
+	stub.createTable("orders", "customer", "order_id")
+	stub.createTable("documents", "id", "")
+	orders := newFakeDynamoTable[testOrder](t, stub, "orders")
+	documents := newFakeDynamoTable[testDocument](t, stub, "documents")
+
+	assert.Nil(t, orders.Save(&testOrder{Customer: "c1", OrderId: 1, Status: "open", Total: 10}))
+	assert.Nil(t, orders.Save(&testOrder{Customer: "c1", OrderId: 2, Status: "shipped", Total: 20}))
+	assert.Nil(t, documents.Save(&testDocument{Id: "readme", Body: "hello"}))
+
+	order, err := orders.Get(ctx, PartitionKey("customer", "c1").WithSort("order_id", 2))
+	assert.Nil(t, err)
+	assert.Equal(t, &testOrder{Customer: "c1", OrderId: 2, Status: "shipped", Total: 20}, order)
+
+	// Read still reads by a string partition key
+	doc, err := documents.Read("id", "readme")
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", doc.Body)
+
+	_, err = orders.Get(ctx, PartitionKey("customer", "c1").WithSort("order_id", 3))
+	assert.True(t, errors.Is(err, ErrItemNotFound))
+	var dynamoErr *DynamoError
+	assert.True(t, errors.As(err, &dynamoErr))
+	assert.Equal(t, "customer=c1, order_id=3", dynamoErr.Key)
+
+	_, err = documents.Read("id", "missing")
+	assert.True(t, errors.Is(err, ErrItemNotFound))
+
+	assert.Nil(t, orders.Delete(ctx, PartitionKey("customer", "c1").WithSort("order_id", 1)))
+	assert.Nil(t, orders.Delete(ctx, PartitionKey("customer", "c1").WithSort("order_id", 1)))
+	_, err = orders.Get(ctx, PartitionKey("customer", "c1").WithSort("order_id", 1))
+	assert.True(t, errors.Is(err, ErrItemNotFound))
+
+	_, err = orders.Get(ctx, PartitionKey("customer", "c1"))
+	assert.NotNil(t, err)
+
+	stub.fail["GetItem"] = "ProvisionedThroughputExceededException"
+	_, err = orders.Get(ctx, PartitionKey("customer", "c1").WithSort("order_id", 2))
+	assert.True(t, errors.Is(err, ErrThrottled))
+}
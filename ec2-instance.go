@@ -21,6 +21,23 @@ import (
 
 // implements an exponential backoff with time.Sleep() to limit and spread calls out over time
 func expBackoff(ctx context.Context, iteration int, max_ms int) {
+	time.Sleep(backoffDelay(iteration, max_ms))
+}
+
+// expBackoffContext waits like expBackoff but stops early with ctx.Err() when ctx is done
+func expBackoffContext(ctx context.Context, iteration int, max_ms int) error {
+	timer := time.NewTimer(backoffDelay(iteration, max_ms))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func backoffDelay(iteration int, max_ms int) time.Duration {
 
 	rand_ms, _ := rand.Int(rand.Reader, big.NewInt(1000))
 	rand_ms_i := int(rand_ms.Uint64())
@@ -31,7 +48,7 @@ func expBackoff(ctx context.Context, iteration int, max_ms int) {
 	delay_ms := int(math.Min(float64(base_ms + rand_ms_i), float64(max_ms + rand_ms_i)))
 	
 	// cloudy.Info(ctx, "exponential backoff: %d ms, base_ms: %d, rand_ms: %d, n:%d, sq:%d", delay_ms, base_ms, rand_ms_i, iteration, sq)
-	time.Sleep(time.Duration(delay_ms) * time.Millisecond)
+	return time.Duration(delay_ms) * time.Millisecond
 }
 
 func ValidateConfiguration(ctx context.Context, vm *cloudyvm.VirtualMachineConfiguration) error {
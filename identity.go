@@ -0,0 +1,218 @@
+package cloudyaws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/appliedres/cloudy"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// CallerIdentity is who AWS thinks the configured credentials belong to
+type CallerIdentity struct {
+	Account   string
+	Arn       string
+	UserId    string
+	Partition string
+}
+
+// MissingPermissionsError lists the IAM actions the caller is not allowed to perform
+type MissingPermissionsError struct {
+	Principal string
+	Actions   []string
+}
+
+func (e *MissingPermissionsError) Error() string {
+	return fmt.Sprintf("%v is missing permissions: %v", e.Principal, strings.Join(e.Actions, ", "))
+}
+
+// IAM actions each provider needs, checked when ValidatePermissions is set
+var (
+	VirtualMachineManagerActions = []string{
+		"ec2:DescribeInstances", "ec2:RunInstances", "ec2:StartInstances", "ec2:StopInstances",
+		"ec2:TerminateInstances", "ec2:CreateTags", "ec2:DescribeSubnets",
+		"ec2:CreateNetworkInterface", "ec2:DeleteNetworkInterface",
+	}
+	Ec2ControllerActions = append([]string{
+		"ec2:DescribeInstanceTypes", "ec2:DescribeNetworkInterfaces", "servicequotas:ListServiceQuotas",
+	}, VirtualMachineManagerActions...)
+	SecretManagerActions = []string{
+		"secretsmanager:ListSecrets", "secretsmanager:GetSecretValue", "secretsmanager:CreateSecret",
+		"secretsmanager:PutSecretValue", "secretsmanager:DeleteSecret", "secretsmanager:RestoreSecret",
+	}
+	ParameterStoreActions = []string{
+		"ssm:GetParameter", "ssm:GetParametersByPath", "ssm:PutParameter", "ssm:DeleteParameter",
+	}
+	DynamoActions     = []string{"dynamodb:GetItem", "dynamodb:PutItem", "dynamodb:UpdateItem", "dynamodb:DeleteItem", "dynamodb:Query", "dynamodb:Scan", "dynamodb:BatchGetItem", "dynamodb:BatchWriteItem", "dynamodb:ConditionCheckItem", "dynamodb:DescribeTable"}
+	QueueActions      = []string{"sqs:ReceiveMessage", "sqs:SendMessage", "sqs:DeleteMessage"}
+	Route53Actions    = []string{"route53:ListHostedZones", "route53:ChangeResourceRecordSets"}
+	CloudFrontActions = []string{"cloudfront:ListDistributions", "cloudfront:GetDistributionConfig", "cloudfront:UpdateDistribution"}
+)
+
+// GetCallerIdentity calls STS GetCallerIdentity with the configured credentials
+func GetCallerIdentity(ctx context.Context, creds *AwsCredentials) (*CallerIdentity, error) {
+	cfg, err := NewAwsConfig(ctx, creds)
+	if err != nil {
+		return nil, err
+	}
+	return callerIdentity(ctx, creds, cfg)
+}
+
+// callerIdentity is GetCallerIdentity with a config already built from creds
+func callerIdentity(ctx context.Context, creds *AwsCredentials, cfg aws.Config) (*CallerIdentity, error) {
+	client := sts.NewFromConfig(cfg, func(o *sts.Options) {
+		if url := creds.EndpointFor(sts.ServiceID); url != "" {
+			o.BaseEndpoint = aws.String(url)
+		}
+	})
+
+	out, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, fmt.Errorf("AWS credentials are not valid: %w", err)
+	}
+
+	identity := &CallerIdentity{
+		Account: aws.ToString(out.Account),
+		Arn:     aws.ToString(out.Arn),
+		UserId:  aws.ToString(out.UserId),
+	}
+	if parts := strings.SplitN(identity.Arn, ":", 3); len(parts) == 3 {
+		identity.Partition = parts[1]
+	}
+	return identity, nil
+}
+
+// FindMissingPermissions simulates the actions against the caller's IAM policies and returns
+// the ones that are not allowed. The caller needs iam:SimulatePrincipalPolicy on itself, and
+// iam:GetRole when it is an assumed role. Roles that cannot be read are not checked.
+func FindMissingPermissions(ctx context.Context, creds *AwsCredentials, identity *CallerIdentity, actions []string) ([]string, error) {
+	if len(actions) == 0 {
+		return nil, nil
+	}
+	cfg, err := NewAwsConfig(ctx, creds)
+	if err != nil {
+		return nil, err
+	}
+	return missingPermissions(ctx, creds, cfg, identity, actions)
+}
+
+// missingPermissions is FindMissingPermissions with a config already built from creds
+func missingPermissions(ctx context.Context, creds *AwsCredentials, cfg aws.Config, identity *CallerIdentity, actions []string) ([]string, error) {
+	if len(actions) == 0 {
+		return nil, nil
+	}
+
+	principal, role, err := identity.policySource()
+	if err != nil {
+		return nil, err
+	}
+	if principal == "" && role == "" {
+		// The account root user is allowed everything
+		return nil, nil
+	}
+
+	client := iam.NewFromConfig(cfg, func(o *iam.Options) {
+		if url := creds.EndpointFor(iam.ServiceID); url != "" {
+			o.BaseEndpoint = aws.String(url)
+		}
+	})
+	if role != "" {
+		// The session ARN leaves out the path of the role, which SSO roles always have
+		out, err := client.GetRole(ctx, &iam.GetRoleInput{RoleName: aws.String(role)})
+		if err != nil {
+			cloudy.Warn(ctx, "AWS permissions not validated, could not read role %v: %v", role, err)
+			return nil, nil
+		}
+		principal = aws.ToString(out.Role.Arn)
+	}
+
+	var missing []string
+	paginator := iam.NewSimulatePrincipalPolicyPaginator(client, &iam.SimulatePrincipalPolicyInput{
+		PolicySourceArn: aws.String(principal),
+		ActionNames:     actions,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not simulate permissions for %v: %w", principal, err)
+		}
+		for _, result := range page.EvaluationResults {
+			if result.EvalDecision != iamtypes.PolicyEvaluationDecisionTypeAllowed {
+				missing = append(missing, aws.ToString(result.EvalActionName))
+			}
+		}
+	}
+	return missing, nil
+}
+
+// ValidateAwsCredentials checks that the credentials work and, when actions are given, that
+// they allow all of them. Missing actions are reported as a *MissingPermissionsError.
+func ValidateAwsCredentials(ctx context.Context, creds *AwsCredentials, actions ...string) (*CallerIdentity, error) {
+	cfg, err := NewAwsConfig(ctx, creds)
+	if err != nil {
+		return nil, err
+	}
+	return validateAwsConfig(ctx, creds, cfg, actions)
+}
+
+// validateAwsConfig is ValidateAwsCredentials with a config already built from creds
+func validateAwsConfig(ctx context.Context, creds *AwsCredentials, cfg aws.Config, actions []string) (*CallerIdentity, error) {
+	identity, err := callerIdentity(ctx, creds, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	missing, err := missingPermissions(ctx, creds, cfg, identity, actions)
+	if err != nil {
+		return identity, err
+	}
+	if len(missing) > 0 {
+		return identity, &MissingPermissionsError{Principal: identity.Arn, Actions: missing}
+	}
+	return identity, nil
+}
+
+// validateOnConfigure runs the checks requested on the credentials, if any, with the config
+// the provider was configured with so the credentials are not resolved again
+func validateOnConfigure(ctx context.Context, creds *AwsCredentials, cfg aws.Config, actions []string) error {
+	if !creds.ValidateIdentity && !creds.ValidatePermissions {
+		return nil
+	}
+	if !creds.ValidatePermissions {
+		actions = nil
+	}
+
+	identity, err := validateAwsConfig(ctx, creds, cfg, actions)
+	if err != nil {
+		return err
+	}
+	cloudy.Info(ctx, "AWS credentials validated for %s in account %s", identity.Arn, identity.Account)
+	return nil
+}
+
+// policySource maps the caller to the IAM entity that owns its policies. Users return their
+// own ARN, assumed role sessions return the name of the role to look up and the root user
+// returns neither.
+func (identity *CallerIdentity) policySource() (arn string, role string, err error) {
+	// arn:partition:service::account:resource
+	parts := strings.SplitN(identity.Arn, ":", 6)
+	if len(parts) != 6 {
+		return "", "", fmt.Errorf("unexpected caller ARN: %v", identity.Arn)
+	}
+	service, resource := parts[2], parts[5]
+
+	switch {
+	case service == "iam" && resource == "root":
+		return "", "", nil
+	case service == "iam":
+		return identity.Arn, "", nil
+	case service == "sts" && strings.HasPrefix(resource, "assumed-role/"):
+		return "", strings.Split(strings.TrimPrefix(resource, "assumed-role/"), "/")[0], nil
+	default:
+		return "", "", fmt.Errorf("cannot simulate permissions for %v", identity.Arn)
+	}
+}
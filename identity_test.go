@@ -0,0 +1,220 @@
+package cloudyaws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Paths of the roles the fake IAM knows, in account 123456789012
+var fakeIAMRoles = map[string]string{
+	"deploy":                                "/",
+	"AWSReservedSSO_Admin_0123456789abcdef": "/aws-reserved/sso.amazonaws.com/",
+}
+
+// newFakeIAM answers GetRole and SimulatePrincipalPolicy, allowing only the given actions
+func newFakeIAM(t *testing.T, allowed ...string) (*httptest.Server, *[]string) {
+	var principals []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.Form.Get("Action") == "GetRole" {
+			name := r.Form.Get("RoleName")
+			path, ok := fakeIAMRoles[name]
+			w.Header().Set("Content-Type", "text/xml")
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprintf(w, `<ErrorResponse><Error><Type>Sender</Type><Code>NoSuchEntity</Code><Message>role %s not found</Message></Error><RequestId>req</RequestId></ErrorResponse>`, name)
+				return
+			}
+			fmt.Fprintf(w, `<GetRoleResponse xmlns="https://iam.amazonaws.com/doc/2010-05-08/">
+  <GetRoleResult>
+    <Role><Path>%s</Path><RoleName>%s</RoleName><RoleId>AROA</RoleId><Arn>arn:aws:iam::123456789012:role%s%s</Arn><CreateDate>2024-01-01T00:00:00Z</CreateDate></Role>
+  </GetRoleResult>
+  <ResponseMetadata><RequestId>req</RequestId></ResponseMetadata>
+</GetRoleResponse>`, path, name, path, name)
+			return
+		}
+		if r.Form.Get("Action") != "SimulatePrincipalPolicy" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		principals = append(principals, r.Form.Get("PolicySourceArn"))
+
+		var results strings.Builder
+		for i := 1; r.Form.Get(fmt.Sprintf("ActionNames.member.%d", i)) != ""; i++ {
+			action := r.Form.Get(fmt.Sprintf("ActionNames.member.%d", i))
+			decision := "implicitDeny"
+			for _, a := range allowed {
+				if a == action {
+					decision = "allowed"
+				}
+			}
+			fmt.Fprintf(&results, `<member><EvalActionName>%s</EvalActionName><EvalResourceName>*</EvalResourceName><EvalDecision>%s</EvalDecision></member>`, action, decision)
+		}
+
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, `<SimulatePrincipalPolicyResponse xmlns="https://iam.amazonaws.com/doc/2010-05-08/">
+  <SimulatePrincipalPolicyResult>
+    <IsTruncated>false</IsTruncated>
+    <EvaluationResults>%s</EvaluationResults>
+  </SimulatePrincipalPolicyResult>
+  <ResponseMetadata><RequestId>req</RequestId></ResponseMetadata>
+</SimulatePrincipalPolicyResponse>`, results.String())
+	}))
+	t.Cleanup(server.Close)
+	return server, &principals
+}
+
+func TestGetCallerIdentity(t *testing.T) {
+	isolateAwsEnv(t)
+	stub := newFakeSTS(t)
+
+	identity, err := GetCallerIdentity(context.Background(), &AwsCredentials{
+		Type:            CredTypeSecret,
+		Region:          "us-east-1",
+		AccessKeyID:     "AKIDTEST",
+		SecretAccessKey: "secret",
+		Endpoints:       map[string]string{"sts": stub.URL},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "123456789012", identity.Account)
+	assert.Equal(t, "arn:aws:iam::123456789012:user/AKIDTEST", identity.Arn)
+	assert.Equal(t, "aws", identity.Partition)
+}
+
+func TestValidateAwsCredentials(t *testing.T) {
+	isolateAwsEnv(t)
+	stub := newFakeSTS(t)
+	iamServer, principals := newFakeIAM(t, "ec2:DescribeInstances")
+
+	creds := &AwsCredentials{
+		Type:            CredTypeSecret,
+		Region:          "us-east-1",
+		AccessKeyID:     "AKIDTEST",
+		SecretAccessKey: "secret",
+		Endpoints:       map[string]string{"sts": stub.URL, "iam": iamServer.URL},
+	}
+
+	identity, err := ValidateAwsCredentials(context.Background(), creds, "ec2:DescribeInstances")
+	assert.Nil(t, err)
+	assert.Equal(t, "123456789012", identity.Account)
+
+	_, err = ValidateAwsCredentials(context.Background(), creds, "ec2:DescribeInstances", "ec2:RunInstances", "ec2:CreateTags")
+	var missing *MissingPermissionsError
+	assert.True(t, errors.As(err, &missing))
+	assert.Equal(t, []string{"ec2:RunInstances", "ec2:CreateTags"}, missing.Actions)
+	assert.Equal(t, []string{identity.Arn, identity.Arn}, *principals)
+
+	// Identity only checks skip the simulation
+	cfg, err := NewAwsConfig(context.Background(), creds)
+	assert.Nil(t, err)
+	creds.ValidateIdentity = true
+	assert.Nil(t, validateOnConfigure(context.Background(), creds, cfg, VirtualMachineManagerActions))
+	assert.Len(t, *principals, 2)
+
+	creds.ValidatePermissions = true
+	err = validateOnConfigure(context.Background(), creds, cfg, VirtualMachineManagerActions)
+	assert.True(t, errors.As(err, &missing))
+	assert.NotContains(t, missing.Actions, "ec2:DescribeInstances")
+}
+
+func TestPolicySource(t *testing.T) {
+	tests := []struct {
+		arn  string
+		user string
+		role string
+		err  bool
+	}{
+		{"arn:aws:iam::123456789012:user/alice", "arn:aws:iam::123456789012:user/alice", "", false},
+		{"arn:aws-us-gov:sts::123456789012:assumed-role/deploy/session", "", "deploy", false},
+		{"arn:aws:sts::123456789012:assumed-role/AWSReservedSSO_Admin_0123456789abcdef/alice", "", "AWSReservedSSO_Admin_0123456789abcdef", false},
+		{"arn:aws:iam::123456789012:root", "", "", false},
+		{"arn:aws:sts::123456789012:federated-user/bob", "", "", true},
+		{"not-an-arn", "", "", true},
+	}
+
+	for _, tt := range tests {
+		user, role, err := (&CallerIdentity{Arn: tt.arn}).policySource()
+		assert.Equal(t, tt.err, err != nil, tt.arn)
+		assert.Equal(t, tt.user, user, tt.arn)
+		assert.Equal(t, tt.role, role, tt.arn)
+	}
+}
+
+func TestMissingPermissionsOfRoles(t *testing.T) {
+	isolateAwsEnv(t)
+	iamServer, principals := newFakeIAM(t, "ec2:DescribeInstances")
+	creds := &AwsCredentials{
+		Type:            CredTypeSecret,
+		Region:          "us-east-1",
+		AccessKeyID:     "AKIDTEST",
+		SecretAccessKey: "secret",
+		Endpoints:       map[string]string{"iam": iamServer.URL},
+	}
+	ctx := context.Background()
+	cfg, err := NewAwsConfig(ctx, creds)
+	assert.Nil(t, err)
+
+	// Roles are simulated with the ARN IAM has for them, path included
+	sso := &CallerIdentity{Arn: "arn:aws:sts::123456789012:assumed-role/AWSReservedSSO_Admin_0123456789abcdef/alice"}
+	missing, err := missingPermissions(ctx, creds, cfg, sso, []string{"ec2:DescribeInstances", "ec2:RunInstances"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ec2:RunInstances"}, missing)
+	assert.Equal(t, []string{"arn:aws:iam::123456789012:role/aws-reserved/sso.amazonaws.com/AWSReservedSSO_Admin_0123456789abcdef"}, *principals)
+
+	// A role that cannot be read is not checked
+	unknown := &CallerIdentity{Arn: "arn:aws:sts::123456789012:assumed-role/unknown/session"}
+	missing, err = missingPermissions(ctx, creds, cfg, unknown, []string{"ec2:RunInstances"})
+	assert.Nil(t, err)
+	assert.Empty(t, missing)
+	assert.Len(t, *principals, 1)
+}
+
+func TestValidateOnConfigureSharesCredentials(t *testing.T) {
+	isolateAwsEnv(t)
+	stub := newFakeSTS(t)
+
+	prompts := 0
+	creds := AwsCredentials{
+		Type:             CredTypeAssumeRole,
+		Region:           "us-east-1",
+		SourceType:       CredTypeStatic,
+		AccessKeyID:      "BASEKEY",
+		SecretAccessKey:  "secret",
+		RoleArn:          "arn:aws:iam::111111111111:role/tooling",
+		MFASerial:        "arn:aws:iam::111111111111:mfa/me",
+		MFATokenProvider: func() (string, error) { prompts++; return "123456", nil },
+		Endpoints:        map[string]string{"sts": stub.URL},
+		ValidateIdentity: true,
+	}
+
+	// Validating and then using the client assume the role, and ask for a token, once
+	sm, err := NewSecretManager(context.Background(), creds)
+	assert.Nil(t, err)
+	value, err := sm.Client.Options().Credentials.Retrieve(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "KEY-tooling", value.AccessKeyID)
+	assert.Equal(t, 1, prompts)
+
+	// The v1 clients share the provider the same way
+	q, err := NewQueue(context.Background(), &creds)
+	assert.Nil(t, err)
+	v1Value, err := q.Client.Config.Credentials.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, "KEY-tooling", v1Value.AccessKeyID)
+	assert.Equal(t, 2, prompts)
+
+	assumed := 0
+	for _, call := range stub.calls {
+		if call.RoleArn != "" {
+			assumed++
+		}
+	}
+	assert.Equal(t, 2, assumed)
+}
@@ -0,0 +1,188 @@
+package cloudyaws
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Partition is a group of AWS regions that share an ARN prefix, DNS suffix and feature set.
+// Resources never cross a partition, so anything that builds an ARN or picks an AWS owned
+// resource ID has to know which one it is in.
+type Partition struct {
+	ID                 string // ARN prefix: aws, aws-us-gov, aws-iso...
+	Name               string
+	DNSSuffix          string
+	DualStackDNSSuffix string // "" when the partition has no dual-stack endpoints
+	DefaultRegion      string
+	SupportsFIPS       bool
+
+	// Route 53 alias target zone for CloudFront distributions, "" when CloudFront is unavailable
+	CloudFrontHostedZoneID string
+
+	regionRegex *regexp.Regexp
+}
+
+const (
+	PartitionAws      = "aws"
+	PartitionAwsCn    = "aws-cn"
+	PartitionAwsUsGov = "aws-us-gov"
+	PartitionAwsIso   = "aws-iso"
+	PartitionAwsIsoB  = "aws-iso-b"
+	PartitionAwsIsoE  = "aws-iso-e"
+	PartitionAwsIsoF  = "aws-iso-f"
+)
+
+// Partitions known to this package. GovCloud is listed before the commercial partition
+// since both use the "us-" prefix.
+var Partitions = []*Partition{
+	{
+		ID:                 PartitionAwsUsGov,
+		Name:               "AWS GovCloud (US)",
+		DNSSuffix:          "amazonaws.com",
+		DualStackDNSSuffix: "api.aws",
+		DefaultRegion:      DefaultRegion,
+		SupportsFIPS:       true,
+		regionRegex:        regexp.MustCompile(`^us-gov-\w+-\d+$`),
+	},
+	{
+		ID:                     PartitionAws,
+		Name:                   "AWS Standard",
+		DNSSuffix:              "amazonaws.com",
+		DualStackDNSSuffix:     "api.aws",
+		DefaultRegion:          "us-east-1",
+		SupportsFIPS:           true,
+		CloudFrontHostedZoneID: "Z2FDTNDATAQYW2",
+		regionRegex:            regexp.MustCompile(`^(us|eu|ap|sa|ca|me|af|il|mx)-\w+-\d+$`),
+	},
+	{
+		ID:                     PartitionAwsCn,
+		Name:                   "AWS China",
+		DNSSuffix:              "amazonaws.com.cn",
+		DualStackDNSSuffix:     "api.amazonwebservices.com.cn",
+		DefaultRegion:          "cn-north-1",
+		CloudFrontHostedZoneID: "Z3RFFRIM2A3IF5",
+		regionRegex:            regexp.MustCompile(`^cn-\w+-\d+$`),
+	},
+	{
+		ID:            PartitionAwsIso,
+		Name:          "AWS ISO (US)",
+		DNSSuffix:     "c2s.ic.gov",
+		DefaultRegion: "us-iso-east-1",
+		SupportsFIPS:  true,
+		regionRegex:   regexp.MustCompile(`^us-iso-\w+-\d+$`),
+	},
+	{
+		ID:            PartitionAwsIsoB,
+		Name:          "AWS ISOB (US)",
+		DNSSuffix:     "sc2s.sgov.gov",
+		DefaultRegion: "us-isob-east-1",
+		SupportsFIPS:  true,
+		regionRegex:   regexp.MustCompile(`^us-isob-\w+-\d+$`),
+	},
+	{
+		ID:            PartitionAwsIsoE,
+		Name:          "AWS ISOE (Europe)",
+		DNSSuffix:     "cloud.adc-e.uk",
+		DefaultRegion: "eu-isoe-west-1",
+		SupportsFIPS:  true,
+		regionRegex:   regexp.MustCompile(`^eu-isoe-\w+-\d+$`),
+	},
+	{
+		ID:            PartitionAwsIsoF,
+		Name:          "AWS ISOF",
+		DNSSuffix:     "csp.hci.ic.gov",
+		DefaultRegion: "us-isof-south-1",
+		SupportsFIPS:  true,
+		regionRegex:   regexp.MustCompile(`^us-isof-\w+-\d+$`),
+	},
+}
+
+// Region aliases, matched after lower casing and dropping '-' and '_'
+var regionAliases = map[string]string{
+	RegionPublic:       PartitionAws,
+	"commercial":       PartitionAws,
+	"aws":              PartitionAws,
+	"china":            PartitionAwsCn,
+	"awscn":            PartitionAwsCn,
+	RegionUSGovernment: PartitionAwsUsGov,
+	"usgov":            PartitionAwsUsGov,
+	"govcloud":         PartitionAwsUsGov,
+	"awsusgov":         PartitionAwsUsGov,
+	"awsiso":           PartitionAwsIso,
+	"awsisob":          PartitionAwsIsoB,
+	"awsisoe":          PartitionAwsIsoE,
+	"awsisof":          PartitionAwsIsoF,
+}
+
+// GetPartition looks up a partition by ID
+func GetPartition(id string) (*Partition, error) {
+	for _, p := range Partitions {
+		if p.ID == id {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown AWS partition: %v", id)
+}
+
+// PartitionForRegion returns the partition a region belongs to
+func PartitionForRegion(region string) (*Partition, error) {
+	for _, p := range Partitions {
+		if p.regionRegex.MatchString(region) {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("region %v is not in a known AWS partition", region)
+}
+
+// ResolveRegion turns a region or alias ("usgovernment", "public", "aws-iso") into a region
+// name. An empty region resolves to DefaultRegion.
+func ResolveRegion(region string) (string, error) {
+	if region == "" {
+		return DefaultRegion, nil
+	}
+
+	alias := strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(region))
+	if id, ok := regionAliases[alias]; ok {
+		p, err := GetPartition(id)
+		if err != nil {
+			return "", err
+		}
+		return p.DefaultRegion, nil
+	}
+
+	region = strings.ToLower(region)
+	if _, err := PartitionForRegion(region); err != nil {
+		return "", err
+	}
+	return region, nil
+}
+
+// ARN builds an ARN in this partition
+func (p *Partition) ARN(service string, region string, account string, resource string) string {
+	return fmt.Sprintf("arn:%v:%v:%v:%v:%v", p.ID, service, region, account, resource)
+}
+
+// ResolveRegion resolves the configured region and checks it against the configured
+// partition and endpoint flags.
+func (creds *AwsCredentials) ResolveRegion() (string, *Partition, error) {
+	region, err := ResolveRegion(creds.Region)
+	if err != nil {
+		return "", nil, err
+	}
+	p, err := PartitionForRegion(region)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if creds.Partition != "" && creds.Partition != p.ID {
+		return "", nil, fmt.Errorf("region %v is in partition %v, not %v", region, p.ID, creds.Partition)
+	}
+	if creds.UseFIPS && !p.SupportsFIPS {
+		return "", nil, fmt.Errorf("partition %v has no FIPS endpoints", p.ID)
+	}
+	if creds.UseDualStack && p.DualStackDNSSuffix == "" {
+		return "", nil, fmt.Errorf("partition %v has no dual-stack endpoints", p.ID)
+	}
+	return region, p, nil
+}
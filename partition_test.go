@@ -0,0 +1,64 @@
+package cloudyaws
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveRegion(t *testing.T) {
+	tests := []struct {
+		region    string
+		want      string
+		partition string
+		wantErr   bool
+	}{
+		{region: "", want: DefaultRegion, partition: PartitionAwsUsGov},
+		{region: "us-east-1", want: "us-east-1", partition: PartitionAws},
+		{region: "US-WEST-2", want: "us-west-2", partition: PartitionAws},
+		{region: "us-gov-west-1", want: "us-gov-west-1", partition: PartitionAwsUsGov},
+		{region: "usgovernment", want: DefaultRegion, partition: PartitionAwsUsGov},
+		{region: "US_Government", want: DefaultRegion, partition: PartitionAwsUsGov},
+		{region: "public", want: "us-east-1", partition: PartitionAws},
+		{region: "cn-northwest-1", want: "cn-northwest-1", partition: PartitionAwsCn},
+		{region: "us-iso-east-1", want: "us-iso-east-1", partition: PartitionAwsIso},
+		{region: "us-isob-east-1", want: "us-isob-east-1", partition: PartitionAwsIsoB},
+		{region: "aws-iso-f", want: "us-isof-south-1", partition: PartitionAwsIsoF},
+		{region: "eu-isoe-west-1", want: "eu-isoe-west-1", partition: PartitionAwsIsoE},
+		{region: "azureusgoverment", wantErr: true},
+		{region: "mars-north-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.region, func(t *testing.T) {
+			region, err := ResolveRegion(tt.region)
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, region)
+
+			p, err := PartitionForRegion(region)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.partition, p.ID)
+		})
+	}
+}
+
+func TestCredentialsResolveRegion(t *testing.T) {
+	region, p, err := (&AwsCredentials{Region: "us-gov-east-1", Partition: PartitionAwsUsGov, UseFIPS: true}).ResolveRegion()
+	assert.Nil(t, err)
+	assert.Equal(t, "us-gov-east-1", region)
+	assert.Equal(t, "arn:aws-us-gov:iam::123456789012:role/test", p.ARN("iam", "", "123456789012", "role/test"))
+	assert.Equal(t, "", p.CloudFrontHostedZoneID)
+
+	_, _, err = (&AwsCredentials{Region: "us-east-1", Partition: PartitionAwsUsGov}).ResolveRegion()
+	assert.NotNil(t, err)
+
+	_, _, err = (&AwsCredentials{Region: "us-iso-east-1", UseDualStack: true}).ResolveRegion()
+	assert.NotNil(t, err)
+
+	_, _, err = (&AwsCredentials{Region: "cn-north-1", UseFIPS: true}).ResolveRegion()
+	assert.NotNil(t, err)
+}
@@ -1,6 +1,8 @@
 package cloudyaws
 
 import (
+	"context"
+	"fmt"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -15,22 +17,27 @@ type AWSCloudFront struct {
 	Client *cloudfront.CloudFront
 }
 
-func NewCloudFront() *AWSCloudFront {
-	cf := &AWSCloudFront{}
-	cf.createSession()
-	return cf
-}
+func NewCloudFront(ctx context.Context, creds *AwsCredentials) (*AWSCloudFront, error) {
+	_, partition, err := creds.ResolveRegion()
+	if err != nil {
+		return nil, err
+	}
+	if partition.CloudFrontHostedZoneID == "" {
+		return nil, fmt.Errorf("CloudFront is not available in partition %v", partition.ID)
+	}
 
-func (awscf *AWSCloudFront) createSession() {
-	if awscf.sess != nil {
-		return
+	sess, cfg, err := newAwsSessionConfig(ctx, creds)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateOnConfigure(ctx, creds, cfg, CloudFrontActions); err != nil {
+		return nil, err
 	}
-	sess, _ := session.NewSession(&aws.Config{
-		Region: aws.String("us-west-2")},
-	)
-	awscf.sess = sess
-	Client := cloudfront.New(sess)
-	awscf.Client = Client
+
+	return &AWSCloudFront{
+		sess:   sess,
+		Client: cloudfront.New(sess),
+	}, nil
 }
 
 func (awscf *AWSCloudFront) GetDNSName(cname string) (string, error) {
@@ -96,26 +103,30 @@ func (awscf *AWSCloudFront) GetDistribution(cname string) (*cloudfront.Distribut
 }
 
 type AWSRoute53 struct {
-	sess   *session.Session
-	Client *route53.Route53
+	sess      *session.Session
+	Client    *route53.Route53
+	Partition *Partition
 }
 
-func NewRoute53() *AWSRoute53 {
-	cf := &AWSRoute53{}
-	cf.createSession()
-	return cf
-}
+func NewRoute53(ctx context.Context, creds *AwsCredentials) (*AWSRoute53, error) {
+	_, partition, err := creds.ResolveRegion()
+	if err != nil {
+		return nil, err
+	}
 
-func (awsroute53 *AWSRoute53) createSession() {
-	if awsroute53.sess != nil {
-		return
+	sess, cfg, err := newAwsSessionConfig(ctx, creds)
+	if err != nil {
+		return nil, err
 	}
-	sess, _ := session.NewSession(&aws.Config{
-		Region: aws.String("us-west-2")},
-	)
-	awsroute53.sess = sess
-	Client := route53.New(sess)
-	awsroute53.Client = Client
+	if err := validateOnConfigure(ctx, creds, cfg, Route53Actions); err != nil {
+		return nil, err
+	}
+
+	return &AWSRoute53{
+		sess:      sess,
+		Client:    route53.New(sess),
+		Partition: partition,
+	}, nil
 }
 
 func (awsroute53 *AWSRoute53) GetHostedZoneID(name string) (string, error) {
@@ -141,13 +152,18 @@ func (awsroute53 *AWSRoute53) GetHostedZoneID(name string) (string, error) {
 }
 
 func (awsroute53 *AWSRoute53) UpsertARec(zoneId string, name string, DNSName string) error {
+	cloudFrontZone := awsroute53.Partition.CloudFrontHostedZoneID
+	if cloudFrontZone == "" {
+		return fmt.Errorf("CloudFront is not available in partition %v", awsroute53.Partition.ID)
+	}
+
 	change := &route53.Change{
 		Action: aws.String("UPSERT"),
 		ResourceRecordSet: &route53.ResourceRecordSet{
 			Name: aws.String(name),
 			Type: aws.String("A"),
 			AliasTarget: &route53.AliasTarget{
-				HostedZoneId:         aws.String("Z2FDTNDATAQYW2"), // Cloud front Zone
+				HostedZoneId:         aws.String(cloudFrontZone),
 				EvaluateTargetHealth: aws.Bool(false),
 				DNSName:              aws.String(DNSName),
 			},
@@ -0,0 +1,236 @@
+package cloudyaws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/appliedres/cloudy"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/smithy-go"
+)
+
+// Most secrets BatchGetSecretValue reads by ID in one call
+const secretBatchSize = 20
+
+// DefaultSecretWorkers is how many secrets GetSecrets reads in parallel when the batch API is
+// not available
+const DefaultSecretWorkers = 8
+
+// BatchSecretErrors holds the errors of the keys a batch read could not return, by key
+type BatchSecretErrors map[string]error
+
+func (e BatchSecretErrors) Error() string {
+	keys := e.keys()
+	msgs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		msgs = append(msgs, fmt.Sprintf("%v: %v", key, e[key]))
+	}
+	return fmt.Sprintf("%v secrets could not be read: %v", len(e), strings.Join(msgs, "; "))
+}
+
+func (e BatchSecretErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, key := range e.keys() {
+		errs = append(errs, e[key])
+	}
+	return errs
+}
+
+func (e BatchSecretErrors) keys() []string {
+	keys := make([]string, 0, len(e))
+	for key := range e {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GetSecrets reads the current string value of each key. Keys that could not be read are
+// left out of the map and reported in a BatchSecretErrors.
+func (a *AwsSecretManager) GetSecrets(ctx context.Context, keys []string) (map[string]string, error) {
+	values, err := a.GetSecretValues(ctx, keys)
+	result := make(map[string]string, len(values))
+	for key, value := range values {
+		result[key] = value.String
+	}
+	return result, err
+}
+
+// GetSecretValues reads the current version of each key with BatchGetSecretValue, 20 keys a
+// call, or with parallel GetSecretValue calls when the batch API is not available. Keys that
+// could not be read are left out of the map and reported in a BatchSecretErrors.
+func (a *AwsSecretManager) GetSecretValues(ctx context.Context, keys []string) (map[string]*SecretValue, error) {
+	cloudy.Info(ctx, "getting %d secrets in region [%s]", len(keys), a.AwsCredentials.Region)
+
+	values := map[string]*SecretValue{}
+	errs := BatchSecretErrors{}
+
+	// Secrets are read by name and returned by key
+	keyOf := map[string]string{}
+	var names []string
+	for _, key := range uniqueKeys(keys) {
+		name, err := a.secretName(key)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+		keyOf[name] = key
+		names = append(names, name)
+	}
+
+	var fallback []string
+	for start := 0; start < len(names); start += secretBatchSize {
+		batch := names[start:min(start+secretBatchSize, len(names))]
+		if a.batchUnsupported.Load() {
+			fallback = append(fallback, batch...)
+			continue
+		}
+
+		err := a.batchGetSecrets(ctx, batch, values, errs)
+		switch {
+		case err == nil:
+		case isBatchUnsupported(err):
+			cloudy.Info(ctx, "AWS SecretManager: BatchGetSecretValue is not available, reading secrets one at a time")
+			a.batchUnsupported.Store(true)
+			fallback = append(fallback, batch...)
+		case errors.Is(err, ErrAccessDenied):
+			// Allowed to read the secrets, just not in a batch
+			fallback = append(fallback, batch...)
+		default:
+			for _, key := range batch {
+				errs[key] = err
+			}
+		}
+	}
+	a.getSecretsParallel(ctx, fallback, values, errs)
+
+	result := make(map[string]*SecretValue, len(values))
+	for name, value := range values {
+		result[keyOf[name]] = value
+	}
+	keyErrs := BatchSecretErrors{}
+	for name, err := range errs {
+		if key, ok := keyOf[name]; ok {
+			keyErrs[key] = err
+		} else {
+			keyErrs[name] = err
+		}
+	}
+	if len(keyErrs) > 0 {
+		return result, keyErrs
+	}
+	return result, nil
+}
+
+// batchGetSecrets reads up to 20 keys in one batch, it only returns an error when the call
+// as a whole failed
+func (a *AwsSecretManager) batchGetSecrets(ctx context.Context, batch []string, values map[string]*SecretValue, errs BatchSecretErrors) error {
+	requested := map[string]bool{}
+	for _, key := range batch {
+		requested[key] = true
+	}
+	// Secrets can be asked for by name or ARN, the results have both
+	keyFor := func(name string, arn string) string {
+		if requested[arn] {
+			return arn
+		}
+		return name
+	}
+
+	paginator := secretsmanager.NewBatchGetSecretValuePaginator(a.Client, &secretsmanager.BatchGetSecretValueInput{
+		SecretIdList: batch,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			// The names of a whole batch would flood the log, the caller has them
+			return mapSecretError(ctx, "BatchGetSecretValue", fmt.Sprintf("%v secrets", len(batch)), err)
+		}
+		for _, entry := range page.SecretValues {
+			key := keyFor(aws.ToString(entry.Name), aws.ToString(entry.ARN))
+			values[key] = &SecretValue{
+				VersionId:   aws.ToString(entry.VersionId),
+				Stages:      entry.VersionStages,
+				CreatedDate: aws.ToTime(entry.CreatedDate),
+				String:      aws.ToString(entry.SecretString),
+				Binary:      entry.SecretBinary,
+			}
+		}
+		for _, apiErr := range page.Errors {
+			key := aws.ToString(apiErr.SecretId)
+			errs[key] = mapSecretError(ctx, "BatchGetSecretValue", key,
+				&smithy.GenericAPIError{Code: aws.ToString(apiErr.ErrorCode), Message: aws.ToString(apiErr.Message)})
+		}
+	}
+
+	for _, key := range batch {
+		if _, ok := values[key]; !ok && errs[key] == nil {
+			errs[key] = &SecretError{Op: "BatchGetSecretValue", Key: key, Kind: ErrSecretNotFound, Err: errors.New("not returned by the batch")}
+		}
+	}
+	return nil
+}
+
+// getSecretsParallel reads the keys one at a time with a bounded number of workers
+func (a *AwsSecretManager) getSecretsParallel(ctx context.Context, keys []string, values map[string]*SecretValue, errs BatchSecretErrors) {
+	workers := a.Workers
+	if workers <= 0 {
+		workers = DefaultSecretWorkers
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan string)
+	for i := 0; i < min(workers, len(keys)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range queue {
+				value, err := a.getRawSecret(ctx, key, "", "")
+
+				mu.Lock()
+				if err != nil {
+					errs[key] = err
+				} else {
+					values[key] = value
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, key := range keys {
+		queue <- key
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// isBatchUnsupported recognises an endpoint that does not implement BatchGetSecretValue
+func isBatchUnsupported(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "UnknownOperationException", "InvalidAction", "NotImplemented":
+		return true
+	}
+	return false
+}
+
+func uniqueKeys(keys []string) []string {
+	seen := map[string]bool{}
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, key)
+		}
+	}
+	return result
+}
@@ -0,0 +1,83 @@
+package cloudyaws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSecrets(t *testing.T) {
+	ctx := context.Background()
+	sm, stub := newFakeSecretManager(t)
+
+	var keys []string
+	want := map[string]string{}
+	for i := 0; i < 45; i++ {
+		key := fmt.Sprintf("app/secret-%02d", i)
+		assert.Nil(t, sm.SaveSecret(ctx, key, fmt.Sprintf("value-%02d", i)))
+		keys = append(keys, key)
+		want[key] = fmt.Sprintf("value-%02d", i)
+	}
+	assert.Nil(t, sm.DeleteSecret(ctx, "app/secret-07"))
+	delete(want, "app/secret-07")
+	arn := fakeSecretArnPrefix + "app/secret-01"
+	want[arn] = "value-01"
+
+	values, err := sm.GetSecrets(ctx, append(keys, "app/missing", "app/secret-03", arn))
+	assert.Equal(t, want, values)
+	assert.Equal(t, 3, stub.callCount("BatchGetSecretValue"))
+	assert.Equal(t, 0, stub.callCount("GetSecretValue"))
+
+	var errs BatchSecretErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Len(t, errs, 2)
+	assert.True(t, errors.Is(errs["app/missing"], ErrSecretNotFound))
+	assert.True(t, errors.Is(errs["app/secret-07"], ErrSecretPendingDeletion))
+	assert.True(t, errors.Is(err, ErrSecretNotFound))
+
+	values, err = sm.GetSecrets(ctx, keys[:3])
+	assert.Nil(t, err)
+	assert.Len(t, values, 3)
+}
+
+func TestGetSecretsFallback(t *testing.T) {
+	ctx := context.Background()
+	sm, stub := newFakeSecretManager(t, func(cfg *AwsSecretManagerConfig) {
+		cfg.Workers = 3
+	})
+
+	var keys []string
+	for i := 0; i < 25; i++ {
+		key := fmt.Sprintf("app/secret-%02d", i)
+		assert.Nil(t, sm.SaveSecretBinary(ctx, key, []byte{byte(i)}))
+		keys = append(keys, key)
+	}
+
+	stub.fail["BatchGetSecretValue"] = "UnknownOperationException"
+	values, err := sm.GetSecretValues(ctx, append(keys, "app/missing"))
+	assert.Len(t, values, 25)
+	assert.Equal(t, []byte{24}, values["app/secret-24"].Binary)
+	var errs BatchSecretErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.True(t, errors.Is(errs["app/missing"], ErrSecretNotFound))
+	assert.Equal(t, 26, stub.callCount("GetSecretValue"))
+
+	// The batch API is not tried again
+	_, err = sm.GetSecrets(ctx, keys[:2])
+	assert.Nil(t, err)
+	assert.Equal(t, 1, stub.callCount("BatchGetSecretValue"))
+
+	// Failures of a whole batch are reported for each of its keys
+	sm, stub = newFakeSecretManager(t, func(cfg *AwsSecretManagerConfig) {
+		cfg.MaxAttempts = 1
+	})
+	stub.fail["BatchGetSecretValue"] = "InternalServiceError"
+	_, err = sm.GetSecrets(ctx, []string{"a", "b"})
+	assert.True(t, errors.As(err, &errs))
+	assert.Len(t, errs, 2)
+	assert.Contains(t, errs["a"].Error(), "BatchGetSecretValue 2 secrets")
+	assert.NotContains(t, errs["a"].Error(), "a,b")
+}
@@ -0,0 +1,246 @@
+package cloudyaws
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/secrets"
+)
+
+const DefaultSecretCacheTTL = 5 * time.Minute
+
+// SecretCacheConfig controls how long CachedSecretManager keeps values
+type SecretCacheConfig struct {
+	// How long a value is served without reading it again, 0 uses DefaultSecretCacheTTL
+	TTL time.Duration
+	// Reads this close to expiry refresh the value in the background, 0 uses a fifth of the TTL
+	RefreshAhead time.Duration
+}
+
+// SecretCacheStats counts cache activity since the cache was created
+type SecretCacheStats struct {
+	Hits          int64
+	Misses        int64
+	Refreshes     int64 // Background refreshes started
+	RefreshErrors int64
+	StaleVersions int64 // Reads that returned the version a write through the cache replaced, which were not kept
+}
+
+// CachedSecretManager is an AwsSecretManager that keeps values in memory. Writes and
+// deletes through the cache invalidate the key, writes made elsewhere, rollbacks included,
+// are seen once the cached value expires.
+type CachedSecretManager struct {
+	Manager *AwsSecretManager
+	Config  SecretCacheConfig
+
+	mu      sync.Mutex
+	entries map[string]*secretCacheEntry
+	now     func() time.Time
+
+	hits, misses, refreshes, refreshErrors, staleVersions atomic.Int64
+}
+
+var _ secrets.SecretProvider = (*CachedSecretManager)(nil)
+
+type secretCacheEntry struct {
+	value      *SecretValue // nil until the first read completes
+	expires    time.Time
+	refreshing bool
+	generation int    // Bumped by invalidation so reads started before it are not stored
+	replaced   string // Version a write through the cache replaced, not stored until another is read
+}
+
+func NewCachedSecretManager(sm *AwsSecretManager, cfg SecretCacheConfig) *CachedSecretManager {
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultSecretCacheTTL
+	}
+	if cfg.RefreshAhead <= 0 || cfg.RefreshAhead >= cfg.TTL {
+		cfg.RefreshAhead = cfg.TTL / 5
+	}
+	return &CachedSecretManager{
+		Manager: sm,
+		Config:  cfg,
+		entries: map[string]*secretCacheEntry{},
+		now:     time.Now,
+	}
+}
+
+func (c *CachedSecretManager) GetSecret(ctx context.Context, key string) (string, error) {
+	value, err := c.get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return value.String, nil
+}
+
+func (c *CachedSecretManager) GetSecretBinary(ctx context.Context, key string) ([]byte, error) {
+	value, err := c.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return value.Binary, nil
+}
+
+func (c *CachedSecretManager) SaveSecret(ctx context.Context, key string, data string) error {
+	return c.write(key, func() error { return c.Manager.SaveSecret(ctx, key, data) })
+}
+
+func (c *CachedSecretManager) SaveSecretBinary(ctx context.Context, key string, secret []byte) error {
+	return c.write(key, func() error { return c.Manager.SaveSecretBinary(ctx, key, secret) })
+}
+
+func (c *CachedSecretManager) DeleteSecret(ctx context.Context, key string) error {
+	defer c.Invalidate(key)
+	return c.Manager.DeleteSecret(ctx, key)
+}
+
+// ListAll lists the keys of every secret, the list is not cached
+func (c *CachedSecretManager) ListAll(ctx context.Context) ([]string, error) {
+	return c.Manager.ListAll(ctx)
+}
+
+// Unwrap returns the AwsSecretManager behind the cache, for versions, rotation, tags, batch
+// reads and the rest of its API. Writes made through it are not seen until the cached value
+// expires or is invalidated.
+func (c *CachedSecretManager) Unwrap() *AwsSecretManager {
+	return c.Manager
+}
+
+// Invalidate drops the cached value of a key, the next read goes to Secrets Manager
+func (c *CachedSecretManager) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok {
+		entry.value = nil
+		entry.generation++
+		entry.replaced = ""
+	}
+}
+
+// InvalidateAll drops every cached value
+func (c *CachedSecretManager) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range c.entries {
+		entry.value = nil
+		entry.generation++
+		entry.replaced = ""
+	}
+}
+
+// write saves a key and invalidates it. The version the cache served before the save is
+// remembered so a read that has not caught up with the write yet is not stored.
+func (c *CachedSecretManager) write(key string, save func() error) error {
+	c.mu.Lock()
+	replaced := ""
+	if entry, ok := c.entries[key]; ok && entry.value != nil {
+		replaced = entry.value.VersionId
+	}
+	c.mu.Unlock()
+
+	err := save()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok {
+		entry.value = nil
+		entry.generation++
+		entry.replaced = ""
+		if err == nil {
+			entry.replaced = replaced
+		}
+	}
+	return err
+}
+
+// Stats returns the cache counters
+func (c *CachedSecretManager) Stats() SecretCacheStats {
+	return SecretCacheStats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Refreshes:     c.refreshes.Load(),
+		RefreshErrors: c.refreshErrors.Load(),
+		StaleVersions: c.staleVersions.Load(),
+	}
+}
+
+// get serves a key from the cache, reading it when missing or expired and refreshing it in
+// the background when it is about to expire
+func (c *CachedSecretManager) get(ctx context.Context, key string) (*SecretValue, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &secretCacheEntry{}
+		c.entries[key] = entry
+	}
+	now := c.now()
+	if entry.value != nil && now.Before(entry.expires) {
+		value := entry.value
+		if !entry.refreshing && !now.Before(entry.expires.Add(-c.Config.RefreshAhead)) {
+			entry.refreshing = true
+			c.refreshes.Add(1)
+			go c.refresh(context.WithoutCancel(ctx), key, entry.generation)
+		}
+		c.mu.Unlock()
+		c.hits.Add(1)
+		return value, nil
+	}
+	generation := entry.generation
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	name, err := c.Manager.secretName(key)
+	if err != nil {
+		return nil, err
+	}
+	value, err := c.Manager.getRawSecret(ctx, name, "", "")
+	if err != nil {
+		return nil, err
+	}
+	return c.store(key, generation, value), nil
+}
+
+// refresh reads a key again ahead of its expiry, keeping the cached value on failure
+func (c *CachedSecretManager) refresh(ctx context.Context, key string, generation int) {
+	// The key was resolved when it was first read
+	name, _ := c.Manager.secretName(key)
+	value, err := c.Manager.getRawSecret(ctx, name, "", "")
+	if err != nil {
+		c.refreshErrors.Add(1)
+		cloudy.Info(ctx, "AWS SecretManager: refreshing cached secret [%s] failed: %v", key, err)
+
+		c.mu.Lock()
+		c.entries[key].refreshing = false
+		c.mu.Unlock()
+		return
+	}
+	c.store(key, generation, value)
+}
+
+// store caches a value read at the given generation and returns the value to serve. The
+// read is trusted, except for the version a write through the cache replaced, which is
+// served but not stored until Secrets Manager returns another version.
+func (c *CachedSecretManager) store(key string, generation int, value *SecretValue) *SecretValue {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.entries[key]
+	entry.refreshing = false
+	if entry.generation != generation {
+		// Invalidated while reading, serve what was read but do not keep it
+		return value
+	}
+	if entry.replaced != "" {
+		if value.VersionId == entry.replaced {
+			c.staleVersions.Add(1)
+			return value
+		}
+		entry.replaced = ""
+	}
+	entry.value = value
+	entry.expires = c.now().Add(c.Config.TTL)
+	return value
+}
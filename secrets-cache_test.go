@@ -0,0 +1,174 @@
+package cloudyaws
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSecretCache(t *testing.T) (*CachedSecretManager, *AwsSecretManager, *fakeSecretsManager, *time.Time) {
+	sm, stub := newFakeSecretManager(t)
+	cache := NewCachedSecretManager(sm, SecretCacheConfig{TTL: time.Minute, RefreshAhead: 10 * time.Second})
+	now := time.Unix(1700000000, 0)
+	cache.now = func() time.Time { return now }
+	return cache, sm, stub, &now
+}
+
+// waitForRefresh blocks until the background refresh of a key has finished
+func waitForRefresh(t *testing.T, cache *CachedSecretManager, key string) {
+	assert.Eventually(t, func() bool {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		return !cache.entries[key].refreshing
+	}, time.Second, time.Millisecond)
+}
+
+func (stub *fakeSecretsManager) callCount(op string) int {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	return stub.calls[op]
+}
+
+func TestSecretCache(t *testing.T) {
+	ctx := context.Background()
+	cache, sm, stub, now := newTestSecretCache(t)
+
+	assert.Nil(t, cache.SaveSecret(ctx, "app/db", "v1"))
+	for i := 0; i < 3; i++ {
+		str, err := cache.GetSecret(ctx, "app/db")
+		assert.Nil(t, err)
+		assert.Equal(t, "v1", str)
+	}
+	assert.Equal(t, 1, stub.callCount("GetSecretValue"))
+
+	// Own writes are seen straight away
+	assert.Nil(t, cache.SaveSecret(ctx, "app/db", "v2"))
+	str, err := cache.GetSecret(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, "v2", str)
+
+	// Other writers are seen after a refresh, which serves the cached value meanwhile
+	assert.Nil(t, sm.SaveSecret(ctx, "app/db", "v3"))
+	*now = now.Add(55 * time.Second)
+	str, err = cache.GetSecret(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, "v2", str)
+	waitForRefresh(t, cache, "app/db")
+	str, err = cache.GetSecret(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, "v3", str)
+
+	// A failed refresh keeps serving the cached value
+	*now = now.Add(55 * time.Second)
+	stub.mu.Lock()
+	stub.fail["GetSecretValue"] = "InternalServiceError"
+	stub.mu.Unlock()
+	str, err = cache.GetSecret(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, "v3", str)
+	waitForRefresh(t, cache, "app/db")
+
+	assert.Equal(t, SecretCacheStats{Hits: 5, Misses: 2, Refreshes: 2, RefreshErrors: 1}, cache.Stats())
+
+	// Deleting through the cache invalidates it
+	assert.Nil(t, cache.DeleteSecret(ctx, "app/db"))
+	_, err = cache.GetSecret(ctx, "app/db")
+	assert.True(t, errors.Is(err, ErrSecretPendingDeletion))
+}
+
+func TestSecretCacheRollback(t *testing.T) {
+	ctx := context.Background()
+	cache, _, stub, now := newTestSecretCache(t)
+
+	assert.Nil(t, cache.SaveSecret(ctx, "app/db", "v1"))
+	assert.Nil(t, cache.SaveSecretBinary(ctx, "app/db", []byte("v2")))
+	bin, err := cache.GetSecretBinary(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), bin)
+
+	// A rollback made elsewhere is seen once the cached value expires
+	stub.mu.Lock()
+	secret := stub.secrets[0]
+	stub.moveStage(secret, StageCurrent, secret.Versions[0])
+	stub.mu.Unlock()
+	*now = now.Add(2 * time.Minute)
+	str, err := cache.GetSecret(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", str)
+	assert.Equal(t, int64(0), cache.Stats().StaleVersions)
+}
+
+func TestSecretCacheStaleAfterWrite(t *testing.T) {
+	ctx := context.Background()
+	cache, _, stub, _ := newTestSecretCache(t)
+
+	assert.Nil(t, cache.SaveSecret(ctx, "app/db", "v1"))
+	str, err := cache.GetSecret(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", str)
+
+	// A read that has not caught up with a write through the cache is served but not kept
+	assert.Nil(t, cache.SaveSecret(ctx, "app/db", "v2"))
+	stub.mu.Lock()
+	secret := stub.secrets[0]
+	stub.moveStage(secret, StageCurrent, secret.Versions[0])
+	stub.mu.Unlock()
+	for i := 0; i < 2; i++ {
+		str, err = cache.GetSecret(ctx, "app/db")
+		assert.Nil(t, err)
+		assert.Equal(t, "v1", str)
+	}
+	assert.Equal(t, int64(2), cache.Stats().StaleVersions)
+	assert.Equal(t, 3, stub.callCount("GetSecretValue"))
+
+	stub.mu.Lock()
+	stub.moveStage(secret, StageCurrent, secret.Versions[1])
+	stub.mu.Unlock()
+	str, err = cache.GetSecret(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, "v2", str)
+
+	// Once another version is read the replaced one is trusted again
+	cache.mu.Lock()
+	assert.Equal(t, "", cache.entries["app/db"].replaced)
+	cache.mu.Unlock()
+}
+
+func TestSecretManagerFactoryCache(t *testing.T) {
+	isolateAwsEnv(t)
+	factory := &AwsSecretManagerFactory{}
+	cfg := &AwsSecretManagerConfig{AwsCredentials: AwsCredentials{
+		Type:            CredTypeSecret,
+		Region:          "us-east-1",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+	}}
+
+	provider, err := factory.Create(cfg)
+	assert.Nil(t, err)
+	assert.IsType(t, &AwsSecretManager{}, provider)
+
+	cfg.CacheTTL = time.Minute
+	provider, err = factory.Create(cfg)
+	assert.Nil(t, err)
+	assert.IsType(t, &CachedSecretManager{}, provider)
+	assert.Equal(t, time.Minute, provider.(*CachedSecretManager).Config.TTL)
+
+	// Caching does not hide the rest of the API
+	_, ok := provider.(interface {
+		ListAll(ctx context.Context) ([]string, error)
+	})
+	assert.True(t, ok)
+	unwrapper, ok := provider.(interface{ Unwrap() *AwsSecretManager })
+	assert.True(t, ok)
+	assert.Equal(t, "us-east-1", unwrapper.Unwrap().AwsCredentials.Region)
+
+	sm, _ := newFakeSecretManager(t)
+	assert.Nil(t, sm.SaveSecret(context.Background(), "app/db", "v1"))
+	keys, err := NewCachedSecretManager(sm, SecretCacheConfig{}).ListAll(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"app/db"}, keys)
+}
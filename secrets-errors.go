@@ -0,0 +1,107 @@
+package cloudyaws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/appliedres/cloudy"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+)
+
+// Categories of AwsSecretManager and AwsParameterStore failures, test with errors.Is. The SDK
+// error stays available through errors.As.
+var (
+	ErrSecretNotFound        = errors.New("secret not found")
+	ErrSecretExists          = errors.New("secret already exists")
+	ErrSecretPendingDeletion = errors.New("secret is scheduled for deletion")
+	ErrDecryption            = errors.New("secret could not be decrypted")
+	ErrEncryption            = errors.New("secret could not be encrypted")
+	ErrThrottled             = errors.New("request was throttled")
+	ErrAccessDenied          = errors.New("access denied")
+	ErrVersionConflict       = errors.New("secret version has changed")
+)
+
+// SecretError is returned by every AwsSecretManager call that fails in Secrets Manager, and
+// every AwsParameterStore call that fails in SSM
+type SecretError struct {
+	Op   string // Secrets Manager or SSM operation, e.g. "GetSecretValue"
+	Key  string
+	Kind error // One of the Err* categories, nil when the failure has none
+	Err  error // Error returned by the SDK
+}
+
+func (e *SecretError) Error() string {
+	if e.Kind != nil {
+		return fmt.Sprintf("%v %v: %v: %v", e.Op, e.Key, e.Kind, e.Err)
+	}
+	return fmt.Sprintf("%v %v: %v", e.Op, e.Key, e.Err)
+}
+
+func (e *SecretError) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// Error codes for each category
+var secretErrorKinds = map[string]error{
+	"ResourceNotFoundException": ErrSecretNotFound,
+	"ResourceExistsException":   ErrSecretExists,
+	"DecryptionFailure":         ErrDecryption,
+	"EncryptionFailure":         ErrEncryption,
+	"AccessDeniedException":     ErrAccessDenied,
+	"AccessDenied":              ErrAccessDenied,
+	"KMSAccessDeniedException":  ErrAccessDenied,
+
+	// SSM Parameter Store
+	"ParameterNotFound":      ErrSecretNotFound,
+	"ParameterAlreadyExists": ErrSecretExists,
+}
+
+// mapSecretError wraps an SDK error in a *SecretError with its category. It is the one place
+// Secrets Manager failures are classified and logged.
+func mapSecretError(ctx context.Context, op string, key string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var secretErr *SecretError
+	if errors.As(err, &secretErr) {
+		return err
+	}
+
+	secretErr = &SecretError{Op: op, Key: key, Err: err}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		secretErr.Kind = secretErrorKinds[apiErr.ErrorCode()]
+		switch {
+		case retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err).Bool():
+			secretErr.Kind = ErrThrottled
+		case apiErr.ErrorCode() == "InvalidRequestException" && isDeletionMessage(apiErr.ErrorMessage()):
+			secretErr.Kind = ErrSecretPendingDeletion
+		}
+	}
+
+	cloudy.Info(ctx, "AWS SecretManager: %v", secretErr)
+	return secretErr
+}
+
+// versionConflict is the error for a compare-and-swap that lost to another writer
+func versionConflict(key string, expected string, current string) error {
+	return &SecretError{
+		Op:   "UpdateSecretVersionStage",
+		Key:  key,
+		Kind: ErrVersionConflict,
+		Err:  fmt.Errorf("current version is %v, expected %v", current, expected),
+	}
+}
+
+// isDeletionMessage recognises the InvalidRequestException Secrets Manager returns when the
+// secret, or a secret with the same name, is scheduled for deletion
+func isDeletionMessage(msg string) bool {
+	msg = strings.ToLower(msg)
+	return strings.Contains(msg, "scheduled for deletion") || strings.Contains(msg, "marked for deletion")
+}
@@ -0,0 +1,170 @@
+package cloudyaws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/appliedres/cloudy"
+)
+
+var (
+	ErrSecretNotJSON       = errors.New("secret is not a JSON object")
+	ErrSecretFieldNotFound = errors.New("secret field not found")
+)
+
+// DefaultSecretFieldRetries is how many times SaveSecretFields re-reads and merges after
+// losing a compare-and-swap to another writer
+const DefaultSecretFieldRetries = 5
+
+// GetSecretJSON reads a secret and unmarshals it into a T
+func GetSecretJSON[T any](ctx context.Context, a *AwsSecretManager, key string) (T, error) {
+	var result T
+	key, err := a.secretName(key)
+	if err != nil {
+		return result, err
+	}
+	value, err := a.getRawSecret(ctx, key, "", "")
+	if err != nil {
+		return result, err
+	}
+	if err := json.Unmarshal(value.jsonBytes(), &result); err != nil {
+		return result, fmt.Errorf("%w: %v: %w", ErrSecretNotJSON, key, err)
+	}
+	return result, nil
+}
+
+// SaveSecretJSON marshals v and saves it as the secret
+func (a *AwsSecretManager) SaveSecretJSON(ctx context.Context, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return a.SaveSecret(ctx, key, string(data))
+}
+
+// GetSecretFields reads a secret holding a JSON object. Numbers are returned as json.Number.
+func (a *AwsSecretManager) GetSecretFields(ctx context.Context, key string) (map[string]interface{}, error) {
+	key, err := a.secretName(key)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, _, err := a.getSecretFields(ctx, key)
+	return fields, err
+}
+
+// GetSecretField reads one field of a secret holding a JSON object. Strings are returned as
+// is, any other value as its JSON text.
+func (a *AwsSecretManager) GetSecretField(ctx context.Context, key string, field string) (string, error) {
+	cloudy.Info(ctx, "getting field [%s] of secret with key [%s] in region [%s]", field, key, a.AwsCredentials.Region)
+
+	key, err := a.secretName(key)
+	if err != nil {
+		return "", err
+	}
+
+	fields, _, err := a.getSecretFields(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	v, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("%w: %v in %v", ErrSecretFieldNotFound, field, key)
+	}
+	if str, ok := v.(string); ok {
+		return str, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// SaveSecretFields merges the fields into a secret holding a JSON object, creating it when
+// it does not exist. A nil value removes the field. The write is a compare-and-swap against
+// the version that was read, so concurrent updates to other fields are not lost.
+func (a *AwsSecretManager) SaveSecretFields(ctx context.Context, key string, updates map[string]interface{}) error {
+	cloudy.Info(ctx, "saving fields of secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+
+	key, err := a.secretName(key)
+	if err != nil {
+		return err
+	}
+
+	for n := 0; n <= DefaultSecretFieldRetries; n++ {
+		if n > 0 {
+			if err := expBackoffContext(ctx, n, 8000); err != nil {
+				return err
+			}
+		}
+
+		var fields map[string]interface{}
+		var versionId string
+		fields, versionId, err = a.getSecretFields(ctx, key)
+		if errors.Is(err, ErrSecretNotFound) || errors.Is(err, ErrSecretPendingDeletion) {
+			fields = map[string]interface{}{}
+		} else if err != nil {
+			return err
+		}
+
+		for k, v := range updates {
+			if v == nil {
+				delete(fields, k)
+			} else {
+				fields[k] = v
+			}
+		}
+		data, merr := json.Marshal(fields)
+		if merr != nil {
+			return merr
+		}
+		value := &SecretValue{String: string(data)}
+
+		if versionId == "" {
+			// Two writers creating the secret race on CreateSecret instead
+			_, err = a.createSecretValue(ctx, key, value, SaveOptions{})
+			if errors.Is(err, ErrSecretPendingDeletion) {
+				_, err = a.recreateDeletedSecret(ctx, key, err, value, SaveOptions{})
+			}
+		} else {
+			_, err = a.compareAndSwap(ctx, key, value, SaveOptions{ExpectedVersionId: versionId})
+		}
+		if !errors.Is(err, ErrVersionConflict) && !errors.Is(err, ErrSecretExists) {
+			return err
+		}
+		cloudy.Info(ctx, "secret with key [%s] changed while saving fields, retrying", key)
+	}
+	return err
+}
+
+// getSecretFields reads the current version as a JSON object along with its VersionId
+func (a *AwsSecretManager) getSecretFields(ctx context.Context, key string) (map[string]interface{}, string, error) {
+	value, err := a.getRawSecret(ctx, key, "", "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	var fields map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(value.jsonBytes()))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return nil, "", fmt.Errorf("%w: %v: %w", ErrSecretNotJSON, key, err)
+	}
+	if fields == nil {
+		// The secret is JSON null
+		fields = map[string]interface{}{}
+	}
+	return fields, value.VersionId, nil
+}
+
+// jsonBytes is the document of a JSON secret, stored as a string or as binary
+func (value *SecretValue) jsonBytes() []byte {
+	if value.String == "" && value.Binary != nil {
+		return value.Binary
+	}
+	return []byte(value.String)
+}
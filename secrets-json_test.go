@@ -0,0 +1,101 @@
+package cloudyaws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testDbSecret struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	User     string `json:"username"`
+	Password string `json:"password"`
+}
+
+func TestSecretJSON(t *testing.T) {
+	ctx := context.Background()
+	sm, _ := newFakeSecretManager(t)
+
+	err := sm.SaveSecretJSON(ctx, "app/db", testDbSecret{Host: "db.local", Port: 5432, User: "app", Password: "p1"})
+	assert.Nil(t, err)
+
+	db, err := GetSecretJSON[testDbSecret](ctx, sm, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, "db.local", db.Host)
+	assert.Equal(t, 5432, db.Port)
+
+	password, err := sm.GetSecretField(ctx, "app/db", "password")
+	assert.Nil(t, err)
+	assert.Equal(t, "p1", password)
+	port, err := sm.GetSecretField(ctx, "app/db", "port")
+	assert.Nil(t, err)
+	assert.Equal(t, "5432", port)
+
+	_, err = sm.GetSecretField(ctx, "app/db", "missing")
+	assert.True(t, errors.Is(err, ErrSecretFieldNotFound))
+
+	assert.Nil(t, sm.SaveSecret(ctx, "app/plain", "not json"))
+	_, err = sm.GetSecretField(ctx, "app/plain", "password")
+	assert.True(t, errors.Is(err, ErrSecretNotJSON))
+	_, err = GetSecretJSON[testDbSecret](ctx, sm, "app/plain")
+	assert.True(t, errors.Is(err, ErrSecretNotJSON))
+}
+
+func TestSaveSecretFields(t *testing.T) {
+	ctx := context.Background()
+	sm, stub := newFakeSecretManager(t)
+
+	// Creates the secret when it does not exist
+	assert.Nil(t, sm.SaveSecretFields(ctx, "app/db", map[string]interface{}{"host": "db.local", "password": "p1"}))
+	assert.Nil(t, sm.SaveSecretFields(ctx, "app/db", map[string]interface{}{"password": "p2", "port": 5432}))
+
+	fields, err := sm.GetSecretFields(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"host": "db.local", "password": "p2", "port": json.Number("5432")}, fields)
+
+	// nil removes a field
+	assert.Nil(t, sm.SaveSecretFields(ctx, "app/db", map[string]interface{}{"port": nil}))
+	fields, err = sm.GetSecretFields(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.NotContains(t, fields, "port")
+
+	// Another writer updates a different field between the read and the swap, both survive
+	stub.before["UpdateSecretVersionStage"] = func() {
+		secret := stub.secrets[0]
+		v := stub.addVersion(secret, map[string]interface{}{"SecretString": `{"host":"db.local","password":"p2","username":"other"}`})
+		stub.moveStage(secret, StageCurrent, v)
+	}
+	assert.Nil(t, sm.SaveSecretFields(ctx, "app/db", map[string]interface{}{"password": "p3"}))
+
+	fields, err = sm.GetSecretFields(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"host": "db.local", "password": "p3", "username": "other"}, fields)
+
+	// Waiting to retry the swap stops with the context, every swap conflicts so it never succeeds
+	var conflict func()
+	conflict = func() {
+		secret := stub.secrets[0]
+		stub.moveStage(secret, StageCurrent, stub.addVersion(secret, map[string]interface{}{"SecretString": `{"host":"db.local"}`}))
+		stub.before["UpdateSecretVersionStage"] = conflict
+	}
+	stub.before["UpdateSecretVersionStage"] = conflict
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = sm.SaveSecretFields(timeout, "app/db", map[string]interface{}{"password": "p4"})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, time.Since(start), time.Second)
+	stub.mu.Lock()
+	delete(stub.before, "UpdateSecretVersionStage")
+	stub.mu.Unlock()
+
+	// Fields cannot be merged into a secret that is not a JSON object
+	assert.Nil(t, sm.SaveSecret(ctx, "app/plain", "not json"))
+	err = sm.SaveSecretFields(ctx, "app/plain", map[string]interface{}{"password": "p1"})
+	assert.True(t, errors.Is(err, ErrSecretNotJSON))
+}
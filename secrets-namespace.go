@@ -0,0 +1,80 @@
+package cloudyaws
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrOutsideNamespace is returned for keys that would resolve outside the namespace of a
+// namespaced AwsSecretManager
+var ErrOutsideNamespace = errors.New("key is outside the secret namespace")
+
+// WithPrefix returns a secret manager scoped to prefix inside this one's namespace, e.g.
+// sm.WithPrefix("tenantA") reads "prod/tenantA/db" for the key "db" when sm is scoped to
+// "prod". The new manager shares the client and settings of this one.
+func (a *AwsSecretManager) WithPrefix(prefix string) (*AwsSecretManager, error) {
+	prefix = strings.Trim(prefix, "/")
+	if err := checkNamespaceKey(prefix); err != nil {
+		return nil, err
+	}
+
+	cfg := a.AwsSecretManagerConfig
+	cfg.Namespace = normalizeNamespace(a.Namespace) + prefix
+	return &AwsSecretManager{
+		AwsSecretManagerConfig: cfg,
+		Client:                 a.Client,
+	}, nil
+}
+
+// secretName maps a key to the name of the secret in Secrets Manager. Without a namespace
+// the key is used as is, including ARNs.
+func (a *AwsSecretManager) secretName(key string) (string, error) {
+	namespace := normalizeNamespace(a.Namespace)
+	if namespace == "" {
+		return key, nil
+	}
+	if err := checkNamespaceKey(key); err != nil {
+		return "", err
+	}
+	return namespace + key, nil
+}
+
+// secretKey maps the name of a secret back to its key, false when it is outside the namespace
+func (a *AwsSecretManager) secretKey(name string) (string, bool) {
+	namespace := normalizeNamespace(a.Namespace)
+	if !strings.HasPrefix(name, namespace) {
+		return "", false
+	}
+	return strings.TrimPrefix(name, namespace), true
+}
+
+// scopeFilter limits a listing to the namespace. The server matches names case-insensitively
+// so the results are checked again with secretKey.
+func (a *AwsSecretManager) scopeFilter(filter SecretFilter) SecretFilter {
+	filter.NamePrefix = normalizeNamespace(a.Namespace) + filter.NamePrefix
+	return filter
+}
+
+// checkNamespaceKey refuses keys that could name a secret outside the namespace
+func checkNamespaceKey(key string) error {
+	switch {
+	case key == "", strings.HasPrefix(key, "/"), strings.HasPrefix(key, "arn:"):
+		return fmt.Errorf("%w: %q", ErrOutsideNamespace, key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == ".." || part == "." {
+			return fmt.Errorf("%w: %q", ErrOutsideNamespace, key)
+		}
+	}
+	return nil
+}
+
+// normalizeNamespace turns "prod", "/prod" and "prod/" into "prod/"
+func normalizeNamespace(namespace string) string {
+	namespace = strings.Trim(namespace, "/")
+	if namespace == "" {
+		return ""
+	}
+	return namespace + "/"
+}
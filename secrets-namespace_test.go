@@ -0,0 +1,128 @@
+package cloudyaws
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretNamespace(t *testing.T) {
+	ctx := context.Background()
+	sm, stub := newFakeSecretManager(t, func(cfg *AwsSecretManagerConfig) {
+		cfg.Namespace = "prod"
+	})
+	stub.secrets = []*fakeSecret{
+		{Name: "prod-old/db"},
+		{Name: "PROD/upper"},
+		{Name: "dev/db"},
+	}
+
+	assert.Nil(t, sm.SaveSecret(ctx, "db", "prod-password"))
+	assert.Nil(t, sm.SaveSecretFields(ctx, "app/config", map[string]interface{}{"port": 5432}))
+
+	// Stored under the namespace, read back by key
+	names := []string{}
+	for _, secret := range stub.secrets {
+		names = append(names, secret.Name)
+	}
+	assert.Contains(t, names, "prod/db")
+	assert.Contains(t, names, "prod/app/config")
+
+	secret, err := sm.GetSecret(ctx, "db")
+	assert.Nil(t, err)
+	assert.Equal(t, "prod-password", secret)
+
+	port, err := sm.GetSecretField(ctx, "app/config", "port")
+	assert.Nil(t, err)
+	assert.Equal(t, "5432", port)
+
+	// Listing only sees the namespace, by key
+	all, err := sm.ListAll(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"db", "app/config"}, all)
+
+	page, err := sm.ListSecretsPage(ctx, SecretFilter{NamePrefix: "app/"}, "", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"app/config"}, page.Names)
+
+	values, err := sm.GetSecrets(ctx, []string{"db", "app/config", "../dev/db"})
+	assert.Equal(t, map[string]string{"db": "prod-password", "app/config": `{"port":5432}`}, values)
+	var errs BatchSecretErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Len(t, errs, 1)
+	assert.True(t, errors.Is(errs["../dev/db"], ErrOutsideNamespace))
+
+	assert.Nil(t, sm.DeleteSecret(ctx, "db"))
+	_, err = sm.GetSecret(ctx, "db")
+	assert.True(t, errors.Is(err, ErrSecretPendingDeletion))
+	assert.Nil(t, sm.RestoreSecret(ctx, "db"))
+}
+
+func TestSecretNamespaceRefusesEscapes(t *testing.T) {
+	ctx := context.Background()
+	sm, stub := newFakeSecretManager(t, func(cfg *AwsSecretManagerConfig) {
+		cfg.Namespace = "prod/"
+	})
+	stub.secrets = []*fakeSecret{{Name: "dev/db"}}
+
+	for _, key := range []string{"", "../dev/db", "a/../../dev/db", "./db", "/dev/db", fakeSecretArnPrefix + "dev/db"} {
+		_, err := sm.GetSecret(ctx, key)
+		assert.True(t, errors.Is(err, ErrOutsideNamespace), key)
+
+		err = sm.SaveSecret(ctx, key, "x")
+		assert.True(t, errors.Is(err, ErrOutsideNamespace), key)
+	}
+	assert.Equal(t, 0, stub.callCount("GetSecretValue"))
+	assert.Equal(t, 0, stub.callCount("CreateSecret"))
+
+	_, err := sm.WithPrefix("../dev")
+	assert.True(t, errors.Is(err, ErrOutsideNamespace))
+}
+
+func TestSecretWithPrefix(t *testing.T) {
+	ctx := context.Background()
+	sm, stub := newFakeSecretManager(t, func(cfg *AwsSecretManagerConfig) {
+		cfg.Namespace = "prod"
+	})
+
+	tenantA, err := sm.WithPrefix("tenantA")
+	assert.Nil(t, err)
+	tenantB, err := sm.WithPrefix("/tenantB/")
+	assert.Nil(t, err)
+
+	assert.Nil(t, tenantA.SaveSecret(ctx, "db", "a-password"))
+	assert.Nil(t, tenantB.SaveSecret(ctx, "db", "b-password"))
+	assert.Equal(t, "prod/tenantA/db", stub.secrets[0].Name)
+	assert.Equal(t, "prod/tenantB/db", stub.secrets[1].Name)
+
+	secret, err := tenantA.GetSecret(ctx, "db")
+	assert.Nil(t, err)
+	assert.Equal(t, "a-password", secret)
+
+	secret, err = sm.GetSecret(ctx, "tenantB/db")
+	assert.Nil(t, err)
+	assert.Equal(t, "b-password", secret)
+
+	keys, err := tenantA.ListAll(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"db"}, keys)
+
+	keys, err = sm.ListAll(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tenantA/db", "tenantB/db"}, keys)
+
+	// Without a namespace keys are names, ARNs included
+	root, err := NewSecretManagerFromConfig(ctx, AwsSecretManagerConfig{AwsCredentials: sm.AwsCredentials})
+	assert.Nil(t, err)
+	secret, err = root.GetSecret(ctx, fakeSecretArnPrefix+"prod/tenantA/db")
+	assert.Nil(t, err)
+	assert.Equal(t, "a-password", secret)
+
+	nested, err := root.WithPrefix("prod")
+	assert.Nil(t, err)
+	secret, err = nested.GetSecret(ctx, "tenantB/db")
+	assert.Nil(t, err)
+	assert.Equal(t, "b-password", secret)
+}
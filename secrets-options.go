@@ -0,0 +1,193 @@
+package cloudyaws
+
+import (
+	"context"
+	"sort"
+
+	"github.com/appliedres/cloudy"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+// SecretOptions are the settings of a secret that are fixed when it is created
+type SecretOptions struct {
+	// KMS key ID, ARN or alias to encrypt with, AwsSecretManagerConfig.KmsKeyId when empty
+	KmsKeyId    string
+	Description string
+	Tags        map[string]string
+	// Resource policy document, e.g. to allow reads from another account
+	ResourcePolicy string
+	// Regions to replicate the secret to
+	Replicas []SecretReplica
+}
+
+// SecretReplica is a region a secret is replicated to
+type SecretReplica struct {
+	Region string
+	// KMS key in the replica region, the AWS managed key when empty
+	KmsKeyId string
+}
+
+// CreateSecret creates a new secret with the options and returns the VersionId of its
+// value. It fails with ErrSecretExists if the secret exists.
+func (a *AwsSecretManager) CreateSecret(ctx context.Context, key string, value *SecretValue, opts SecretOptions) (string, error) {
+	key, err := a.secretName(key)
+	if err != nil {
+		return "", err
+	}
+
+	return a.createSecretValue(ctx, key, value, SaveOptions{Secret: opts})
+}
+
+// GetSecretTags returns the tags of a secret
+func (a *AwsSecretManager) GetSecretTags(ctx context.Context, key string) (map[string]string, error) {
+	cloudy.Info(ctx, "getting tags of secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+
+	key, err := a.secretName(key)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := a.Client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{
+		SecretId: aws.String(key),
+	})
+	if err != nil {
+		return nil, mapSecretError(ctx, "DescribeSecret", key, err)
+	}
+
+	tags := map[string]string{}
+	for _, tag := range result.Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tags, nil
+}
+
+// TagSecret adds the tags to a secret, replacing the values of existing keys
+func (a *AwsSecretManager) TagSecret(ctx context.Context, key string, tags map[string]string) error {
+	cloudy.Info(ctx, "tagging secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+
+	key, err := a.secretName(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.Client.TagResource(ctx, &secretsmanager.TagResourceInput{
+		SecretId: aws.String(key),
+		Tags:     toSecretTags(tags),
+	})
+	return mapSecretError(ctx, "TagResource", key, err)
+}
+
+// UntagSecret removes the tags with the given keys from a secret
+func (a *AwsSecretManager) UntagSecret(ctx context.Context, key string, tagKeys ...string) error {
+	cloudy.Info(ctx, "untagging secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+
+	key, err := a.secretName(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.Client.UntagResource(ctx, &secretsmanager.UntagResourceInput{
+		SecretId: aws.String(key),
+		TagKeys:  tagKeys,
+	})
+	return mapSecretError(ctx, "UntagResource", key, err)
+}
+
+// GetSecretPolicy returns the resource policy of a secret, "" when it has none
+func (a *AwsSecretManager) GetSecretPolicy(ctx context.Context, key string) (string, error) {
+	cloudy.Info(ctx, "getting policy of secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+
+	key, err := a.secretName(key)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := a.Client.GetResourcePolicy(ctx, &secretsmanager.GetResourcePolicyInput{
+		SecretId: aws.String(key),
+	})
+	if err != nil {
+		return "", mapSecretError(ctx, "GetResourcePolicy", key, err)
+	}
+	return aws.ToString(result.ResourcePolicy), nil
+}
+
+// PutSecretPolicy replaces the resource policy of a secret. Policies that would make the
+// secret public are rejected.
+func (a *AwsSecretManager) PutSecretPolicy(ctx context.Context, key string, policy string) error {
+	cloudy.Info(ctx, "putting policy of secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+
+	name, err := a.secretName(key)
+	if err != nil {
+		return err
+	}
+	return a.putSecretPolicy(ctx, name, policy)
+}
+
+func (a *AwsSecretManager) putSecretPolicy(ctx context.Context, key string, policy string) error {
+	_, err := a.Client.PutResourcePolicy(ctx, &secretsmanager.PutResourcePolicyInput{
+		SecretId:          aws.String(key),
+		ResourcePolicy:    aws.String(policy),
+		BlockPublicPolicy: aws.Bool(true),
+	})
+	return mapSecretError(ctx, "PutResourcePolicy", key, err)
+}
+
+// DeleteSecretPolicy removes the resource policy of a secret
+func (a *AwsSecretManager) DeleteSecretPolicy(ctx context.Context, key string) error {
+	cloudy.Info(ctx, "deleting policy of secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+
+	key, err := a.secretName(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.Client.DeleteResourcePolicy(ctx, &secretsmanager.DeleteResourcePolicyInput{
+		SecretId: aws.String(key),
+	})
+	return mapSecretError(ctx, "DeleteResourcePolicy", key, err)
+}
+
+// createSecretInput is a CreateSecret request for the key with the options applied, the
+// resource policy is put separately once the secret exists
+func (a *AwsSecretManager) createSecretInput(key string, opts SecretOptions) *secretsmanager.CreateSecretInput {
+	input := &secretsmanager.CreateSecretInput{
+		Name: aws.String(key),
+	}
+	if opts.KmsKeyId == "" {
+		opts.KmsKeyId = a.KmsKeyId
+	}
+	if opts.KmsKeyId != "" {
+		input.KmsKeyId = aws.String(opts.KmsKeyId)
+	}
+	if opts.Description != "" {
+		input.Description = aws.String(opts.Description)
+	}
+	if len(opts.Tags) > 0 {
+		input.Tags = toSecretTags(opts.Tags)
+	}
+	for _, replica := range opts.Replicas {
+		region := smtypes.ReplicaRegionType{Region: aws.String(replica.Region)}
+		if replica.KmsKeyId != "" {
+			region.KmsKeyId = aws.String(replica.KmsKeyId)
+		}
+		input.AddReplicaRegions = append(input.AddReplicaRegions, region)
+	}
+	return input
+}
+
+// toSecretTags converts tags to the SDK type, sorted by key
+func toSecretTags(tags map[string]string) []smtypes.Tag {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := make([]smtypes.Tag, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, smtypes.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+	return result
+}
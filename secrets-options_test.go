@@ -0,0 +1,95 @@
+package cloudyaws
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateSecretOptions(t *testing.T) {
+	ctx := context.Background()
+	sm, stub := newFakeSecretManager(t, func(cfg *AwsSecretManagerConfig) {
+		cfg.KmsKeyId = "alias/default-secrets"
+	})
+	policy := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::210987654321:root"},"Action":"secretsmanager:GetSecretValue","Resource":"*"}]}`
+
+	_, err := sm.CreateSecret(ctx, "app/db", &SecretValue{String: "v1"}, SecretOptions{
+		KmsKeyId:       "alias/app",
+		Description:    "database login",
+		Tags:           map[string]string{"owner": "core", "cost-center": "42"},
+		ResourcePolicy: policy,
+		Replicas:       []SecretReplica{{Region: "us-west-2"}},
+	})
+	assert.Nil(t, err)
+	secret := stub.secrets[0]
+	assert.Equal(t, "alias/app", secret.KmsKeyId)
+	assert.Equal(t, "database login", secret.Description)
+	assert.Equal(t, map[string]string{"owner": "core", "cost-center": "42"}, secret.Tags)
+	assert.Equal(t, policy, secret.Policy)
+	assert.Equal(t, []string{"us-west-2"}, secret.Replicas)
+
+	_, err = sm.CreateSecret(ctx, "app/db", &SecretValue{String: "v2"}, SecretOptions{})
+	assert.True(t, errors.Is(err, ErrSecretExists))
+
+	// Saves that create use the configured key, saves that put leave the secret alone
+	_, err = sm.SaveSecretWithOptions(ctx, "app/api", &SecretValue{String: "k1"}, SaveOptions{
+		Secret: SecretOptions{Description: "api key"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "alias/default-secrets", stub.secrets[1].KmsKeyId)
+	_, err = sm.SaveSecretWithOptions(ctx, "app/api", &SecretValue{String: "k2"}, SaveOptions{
+		Secret: SecretOptions{Description: "changed"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "api key", stub.secrets[1].Description)
+
+	// A create whose policy is rejected leaves no secret behind
+	public := `{"Statement":[{"Effect":"Allow","Principal":"*","Action":"secretsmanager:GetSecretValue"}]}`
+	_, err = sm.CreateSecret(ctx, "app/public", &SecretValue{String: "v1"}, SecretOptions{
+		ResourcePolicy: public,
+		Replicas:       []SecretReplica{{Region: "us-west-2"}},
+	})
+	assert.NotNil(t, err)
+	assert.Len(t, stub.secrets, 2)
+	_, err = sm.SaveSecretWithOptions(ctx, "app/public", &SecretValue{String: "v1"}, SaveOptions{
+		Secret: SecretOptions{ResourcePolicy: policy},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, policy, stub.secrets[2].Policy)
+}
+
+func TestSecretTagsAndPolicy(t *testing.T) {
+	ctx := context.Background()
+	sm, _ := newFakeSecretManager(t)
+	assert.Nil(t, sm.SaveSecret(ctx, "app/db", "v1"))
+
+	assert.Nil(t, sm.TagSecret(ctx, "app/db", map[string]string{"owner": "core", "env": "dev"}))
+	assert.Nil(t, sm.TagSecret(ctx, "app/db", map[string]string{"env": "prod"}))
+	assert.Nil(t, sm.UntagSecret(ctx, "app/db", "owner"))
+	tags, err := sm.GetSecretTags(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"env": "prod"}, tags)
+
+	policy, err := sm.GetSecretPolicy(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Empty(t, policy)
+
+	public := `{"Statement":[{"Effect":"Allow","Principal":"*","Action":"secretsmanager:GetSecretValue"}]}`
+	assert.NotNil(t, sm.PutSecretPolicy(ctx, "app/db", public))
+
+	crossAccount := `{"Statement":[{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::210987654321:root"},"Action":"secretsmanager:GetSecretValue"}]}`
+	assert.Nil(t, sm.PutSecretPolicy(ctx, "app/db", crossAccount))
+	policy, err = sm.GetSecretPolicy(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, crossAccount, policy)
+
+	assert.Nil(t, sm.DeleteSecretPolicy(ctx, "app/db"))
+	policy, err = sm.GetSecretPolicy(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Empty(t, policy)
+
+	err = sm.TagSecret(ctx, "app/missing", map[string]string{"env": "dev"})
+	assert.True(t, errors.Is(err, ErrSecretNotFound))
+}
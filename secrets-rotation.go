@@ -0,0 +1,291 @@
+package cloudyaws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/appliedres/cloudy"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+// RotationRules is when Secrets Manager rotates a secret, set AfterDays or Schedule
+type RotationRules struct {
+	AfterDays int
+	// cron() or rate() expression, e.g. "rate(10 days)"
+	Schedule string
+	// Length of the rotation window, e.g. "3h", the whole period when empty
+	Duration string
+}
+
+// RotationStatus is the rotation configuration of a secret
+type RotationStatus struct {
+	Enabled     bool
+	LambdaArn   string
+	Rules       RotationRules
+	LastRotated time.Time
+}
+
+// EnableRotation turns on rotation of a secret by the Lambda function on the given schedule.
+// With rotateNow the first rotation starts straight away, otherwise at the next scheduled time.
+func (a *AwsSecretManager) EnableRotation(ctx context.Context, key string, lambdaArn string, rules RotationRules, rotateNow bool) error {
+	cloudy.Info(ctx, "enabling rotation of secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+
+	key, err := a.secretName(key)
+	if err != nil {
+		return err
+	}
+
+	input := &secretsmanager.RotateSecretInput{
+		SecretId:          aws.String(key),
+		RotationLambdaARN: aws.String(lambdaArn),
+		RotationRules:     &smtypes.RotationRulesType{},
+		RotateImmediately: aws.Bool(rotateNow),
+	}
+	if rules.AfterDays > 0 {
+		input.RotationRules.AutomaticallyAfterDays = aws.Int64(int64(rules.AfterDays))
+	}
+	if rules.Schedule != "" {
+		input.RotationRules.ScheduleExpression = aws.String(rules.Schedule)
+	}
+	if rules.Duration != "" {
+		input.RotationRules.Duration = aws.String(rules.Duration)
+	}
+
+	_, err = a.Client.RotateSecret(ctx, input)
+	return mapSecretError(ctx, "RotateSecret", key, err)
+}
+
+// RotateSecret starts a rotation of a secret with its configured Lambda function and returns
+// the VersionId of the new version
+func (a *AwsSecretManager) RotateSecret(ctx context.Context, key string) (string, error) {
+	cloudy.Info(ctx, "rotating secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+
+	key, err := a.secretName(key)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := a.Client.RotateSecret(ctx, &secretsmanager.RotateSecretInput{
+		SecretId: aws.String(key),
+	})
+	if err != nil {
+		return "", mapSecretError(ctx, "RotateSecret", key, err)
+	}
+	return aws.ToString(result.VersionId), nil
+}
+
+// CancelRotation turns off rotation of a secret. A rotation in progress is not completed and
+// its version keeps the AWSPENDING label.
+func (a *AwsSecretManager) CancelRotation(ctx context.Context, key string) error {
+	cloudy.Info(ctx, "cancelling rotation of secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+
+	key, err := a.secretName(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.Client.CancelRotateSecret(ctx, &secretsmanager.CancelRotateSecretInput{
+		SecretId: aws.String(key),
+	})
+	return mapSecretError(ctx, "CancelRotateSecret", key, err)
+}
+
+// GetRotation returns the rotation configuration of a secret
+func (a *AwsSecretManager) GetRotation(ctx context.Context, key string) (*RotationStatus, error) {
+	key, err := a.secretName(key)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := a.Client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{
+		SecretId: aws.String(key),
+	})
+	if err != nil {
+		return nil, mapSecretError(ctx, "DescribeSecret", key, err)
+	}
+
+	status := &RotationStatus{
+		Enabled:     aws.ToBool(result.RotationEnabled),
+		LambdaArn:   aws.ToString(result.RotationLambdaARN),
+		LastRotated: aws.ToTime(result.LastRotatedDate),
+	}
+	if rules := result.RotationRules; rules != nil {
+		status.Rules = RotationRules{
+			AfterDays: int(aws.ToInt64(rules.AutomaticallyAfterDays)),
+			Schedule:  aws.ToString(rules.ScheduleExpression),
+			Duration:  aws.ToString(rules.Duration),
+		}
+	}
+	return status, nil
+}
+
+// GenerateSecretPassword returns a random password from Secrets Manager, without characters
+// that commonly break connection strings
+func (a *AwsSecretManager) GenerateSecretPassword(ctx context.Context, length int) (string, error) {
+	result, err := a.Client.GetRandomPassword(ctx, &secretsmanager.GetRandomPasswordInput{
+		PasswordLength:    aws.Int64(int64(length)),
+		ExcludeCharacters: aws.String(`"'/@\:`),
+	})
+	if err != nil {
+		return "", mapSecretError(ctx, "GetRandomPassword", "", err)
+	}
+	return aws.ToString(result.RandomPassword), nil
+}
+
+// Steps of a rotation, the Step of a RotationEvent
+const (
+	RotationStepCreate = "createSecret"
+	RotationStepSet    = "setSecret"
+	RotationStepTest   = "testSecret"
+	RotationStepFinish = "finishSecret"
+)
+
+const DefaultRotationPasswordLength = 32
+
+// RotationEvent is what Secrets Manager sends the rotation Lambda function
+type RotationEvent struct {
+	SecretId           string `json:"SecretId"`
+	ClientRequestToken string `json:"ClientRequestToken"`
+	Step               string `json:"Step"`
+}
+
+// SecretRotator implements the four steps of the Secrets Manager rotation protocol. The new
+// value is staged as AWSPENDING under the token of the event, Set applies it to the service
+// that uses the secret, Test checks it works and the last step makes it AWSCURRENT. Each step
+// can be retried, steps that have already happened are skipped.
+type SecretRotator struct {
+	Manager *AwsSecretManager
+
+	// Creates the new value from the current one. By default a new password replaces the
+	// "password" field of a JSON secret, or the whole value of any other secret.
+	Generate func(ctx context.Context, current *SecretValue) (*SecretValue, error)
+	// Applies the pending value, e.g. changes the database user's password. Required, use
+	// NoRotationStep when the secret is not used by another service.
+	Set func(ctx context.Context, key string, pending *SecretValue) error
+	// Checks the pending value works, e.g. logs in to the database with it. Required, use
+	// NoRotationStep to promote the value untested.
+	Test func(ctx context.Context, key string, pending *SecretValue) error
+
+	// Length of generated passwords, 0 uses DefaultRotationPasswordLength
+	PasswordLength int
+}
+
+// NoRotationStep is a Set or Test that does nothing
+func NoRotationStep(ctx context.Context, key string, pending *SecretValue) error {
+	return nil
+}
+
+// Rotate runs one step of a rotation, call it from the rotation Lambda function handler. The
+// SecretId of the event is the full name or ARN, the namespace of Manager does not apply.
+func (r *SecretRotator) Rotate(ctx context.Context, event RotationEvent) error {
+	key, token := event.SecretId, event.ClientRequestToken
+	cloudy.Info(ctx, "rotation step [%s] of secret with key [%s] version [%s]", event.Step, key, token)
+
+	// Without them the new value would become current without ever being applied
+	if r.Set == nil || r.Test == nil {
+		return fmt.Errorf("rotation of secret %v needs Set and Test, use NoRotationStep to skip them", key)
+	}
+
+	result, err := r.Manager.Client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{
+		SecretId: aws.String(key),
+	})
+	if err != nil {
+		return mapSecretError(ctx, "DescribeSecret", key, err)
+	}
+	if !aws.ToBool(result.RotationEnabled) {
+		return fmt.Errorf("rotation is not enabled for secret %v", key)
+	}
+	stages, ok := result.VersionIdsToStages[token]
+	if !ok {
+		return fmt.Errorf("secret %v has no version %v to rotate", key, token)
+	}
+	isPending := false
+	for _, stage := range stages {
+		if stage == StageCurrent {
+			// Already finished
+			return nil
+		}
+		isPending = isPending || stage == StagePending
+	}
+	if !isPending {
+		return fmt.Errorf("version %v of secret %v is not %v", token, key, StagePending)
+	}
+
+	switch event.Step {
+	case RotationStepCreate:
+		return r.createSecret(ctx, key, token)
+	case RotationStepSet:
+		return r.withPending(ctx, key, token, r.Set)
+	case RotationStepTest:
+		return r.withPending(ctx, key, token, r.Test)
+	case RotationStepFinish:
+		return r.Manager.moveSecretStage(ctx, key, StageCurrent, token)
+	default:
+		return fmt.Errorf("unknown rotation step: %v", event.Step)
+	}
+}
+
+// createSecret stages a new value as AWSPENDING under the token, unless it already exists
+func (r *SecretRotator) createSecret(ctx context.Context, key string, token string) error {
+	current, err := r.Manager.getRawSecret(ctx, key, "", StageCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = r.Manager.getRawSecret(ctx, key, token, StagePending)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, ErrSecretNotFound) {
+		return err
+	}
+
+	generate := r.Generate
+	if generate == nil {
+		generate = r.generatePassword
+	}
+	pending, err := generate(ctx, current)
+	if err != nil {
+		return err
+	}
+
+	staged := *pending
+	staged.Stages = []string{StagePending}
+	_, err = r.Manager.putSecretValue(ctx, key, &staged, SaveOptions{ClientRequestToken: token})
+	return err
+}
+
+func (r *SecretRotator) withPending(ctx context.Context, key string, token string, fn func(ctx context.Context, key string, pending *SecretValue) error) error {
+	pending, err := r.Manager.getRawSecret(ctx, key, token, StagePending)
+	if err != nil {
+		return err
+	}
+	return fn(ctx, key, pending)
+}
+
+// generatePassword is the default Generate
+func (r *SecretRotator) generatePassword(ctx context.Context, current *SecretValue) (*SecretValue, error) {
+	length := r.PasswordLength
+	if length == 0 {
+		length = DefaultRotationPasswordLength
+	}
+	password, err := r.Manager.GenerateSecretPassword(ctx, length)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+	if json.Unmarshal([]byte(current.String), &fields) != nil || fields == nil {
+		return &SecretValue{String: password}, nil
+	}
+	fields["password"] = password
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretValue{String: string(data)}, nil
+}
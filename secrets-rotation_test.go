@@ -0,0 +1,143 @@
+package cloudyaws
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretRotationConfig(t *testing.T) {
+	ctx := context.Background()
+	sm, _ := newFakeSecretManager(t)
+	assert.Nil(t, sm.SaveSecret(ctx, "app/db", "v1"))
+
+	_, err := sm.RotateSecret(ctx, "app/db")
+	assert.NotNil(t, err)
+
+	lambda := "arn:aws:lambda:us-east-1:123456789012:function:rotate-db"
+	assert.Nil(t, sm.EnableRotation(ctx, "app/db", lambda, RotationRules{Schedule: "rate(10 days)", Duration: "2h"}, false))
+	status, err := sm.GetRotation(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.True(t, status.Enabled)
+	assert.Equal(t, lambda, status.LambdaArn)
+	assert.Equal(t, RotationRules{Schedule: "rate(10 days)", Duration: "2h"}, status.Rules)
+
+	// Not rotated until asked
+	value, err := sm.GetSecretStage(ctx, "app/db", StagePending)
+	assert.Nil(t, value)
+	assert.True(t, errors.Is(err, ErrSecretNotFound))
+	versionId, err := sm.RotateSecret(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, versionId)
+
+	assert.Nil(t, sm.CancelRotation(ctx, "app/db"))
+	status, err = sm.GetRotation(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.False(t, status.Enabled)
+}
+
+func TestSecretRotator(t *testing.T) {
+	ctx := context.Background()
+	sm, stub := newFakeSecretManager(t)
+	assert.Nil(t, sm.SaveSecretFields(ctx, "app/db", map[string]interface{}{"username": "app", "password": "old"}))
+	assert.Nil(t, sm.EnableRotation(ctx, "app/db", "arn:aws:lambda:us-east-1:123456789012:function:rotate-db", RotationRules{AfterDays: 30}, false))
+
+	var set, tested []string
+	failTest := true
+	rotator := &SecretRotator{
+		Manager:        sm,
+		PasswordLength: 16,
+		Set: func(ctx context.Context, key string, pending *SecretValue) error {
+			set = append(set, pending.String)
+			return nil
+		},
+		Test: func(ctx context.Context, key string, pending *SecretValue) error {
+			tested = append(tested, pending.String)
+			if failTest {
+				return errors.New("login failed")
+			}
+			return nil
+		},
+	}
+
+	token, err := sm.RotateSecret(ctx, "app/db")
+	assert.Nil(t, err)
+	run := func(step string) error {
+		return rotator.Rotate(ctx, RotationEvent{SecretId: "app/db", ClientRequestToken: token, Step: step})
+	}
+
+	// Only a missing pending version is created, other failures to read it are returned
+	stub.before["GetSecretValue"] = func() {
+		stub.before["GetSecretValue"] = func() { stub.fail["GetSecretValue"] = "InternalServiceError" }
+	}
+	assert.NotNil(t, run(RotationStepCreate))
+	assert.Equal(t, 0, stub.callCount("PutSecretValue"))
+
+	// Steps can be retried
+	assert.Nil(t, run(RotationStepCreate))
+	assert.Nil(t, run(RotationStepCreate))
+	pending, err := sm.GetSecretStage(ctx, "app/db", StagePending)
+	assert.Nil(t, err)
+	assert.Equal(t, token, pending.VersionId)
+	password, err := sm.GetSecretField(ctx, "app/db", "password")
+	assert.Nil(t, err)
+	assert.Equal(t, "old", password)
+
+	assert.Nil(t, run(RotationStepSet))
+	assert.NotNil(t, run(RotationStepTest))
+	failTest = false
+	assert.Nil(t, run(RotationStepTest))
+	assert.Equal(t, []string{pending.String}, set)
+	assert.Equal(t, []string{pending.String, pending.String}, tested)
+
+	assert.Nil(t, run(RotationStepFinish))
+	assert.Nil(t, run(RotationStepFinish))
+	fields, err := sm.GetSecretFields(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, "app", fields["username"])
+	assert.Len(t, fields["password"], 16)
+	previous, err := sm.GetSecretStage(ctx, "app/db", StagePrevious)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"username":"app","password":"old"}`, previous.String)
+
+	// Unknown versions and steps are refused
+	assert.NotNil(t, rotator.Rotate(ctx, RotationEvent{SecretId: "app/db", ClientRequestToken: "unknown", Step: RotationStepCreate}))
+	token, err = sm.RotateSecret(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.NotNil(t, run("rollback"))
+}
+
+func TestSecretRotatorNeedsSetAndTest(t *testing.T) {
+	ctx := context.Background()
+	sm, stub := newFakeSecretManager(t)
+	assert.Nil(t, sm.SaveSecret(ctx, "app/key", "old"))
+	assert.Nil(t, sm.EnableRotation(ctx, "app/key", "arn:aws:lambda:us-east-1:123456789012:function:rotate-key", RotationRules{AfterDays: 30}, false))
+	token, err := sm.RotateSecret(ctx, "app/key")
+	assert.Nil(t, err)
+
+	// Nothing is staged or promoted without them
+	for _, rotator := range []*SecretRotator{
+		{Manager: sm},
+		{Manager: sm, Set: NoRotationStep},
+		{Manager: sm, Test: NoRotationStep},
+	} {
+		for _, step := range []string{RotationStepCreate, RotationStepSet, RotationStepTest, RotationStepFinish} {
+			assert.NotNil(t, rotator.Rotate(ctx, RotationEvent{SecretId: "app/key", ClientRequestToken: token, Step: step}))
+		}
+	}
+	assert.Equal(t, 0, stub.calls["PutSecretValue"])
+	current, err := sm.GetSecret(ctx, "app/key")
+	assert.Nil(t, err)
+	assert.Equal(t, "old", current)
+
+	// Skipping them is a choice
+	rotator := &SecretRotator{Manager: sm, Set: NoRotationStep, Test: NoRotationStep}
+	for _, step := range []string{RotationStepCreate, RotationStepSet, RotationStepTest, RotationStepFinish} {
+		assert.Nil(t, rotator.Rotate(ctx, RotationEvent{SecretId: "app/key", ClientRequestToken: token, Step: step}))
+	}
+	current, err = sm.GetSecret(ctx, "app/key")
+	assert.Nil(t, err)
+	assert.NotEqual(t, "old", current)
+}
@@ -0,0 +1,226 @@
+package cloudyaws
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/secrets"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+const AwsParameterStoreID = "aws-ssm"
+
+func init() {
+	secrets.SecretProviders.Register(AwsParameterStoreID, &AwsParameterStoreFactory{})
+}
+
+type AwsParameterStoreFactory struct{}
+
+type AwsParameterStoreConfig struct {
+	AwsCredentials
+
+	// Path every key is under, e.g. "/myapp/prod". Keys are relative to "/" when empty.
+	Path string
+	// KMS key SecureString parameters are encrypted with, the AWS managed aws/ssm key when empty
+	KmsKeyId string
+	// Tier of saved parameters: Standard, Advanced or Intelligent-Tiering. The service default,
+	// Standard, when empty. Standard parameters hold at most 4 KB.
+	Tier string
+}
+
+// Environment variables read by the factory on top of the credentials
+const (
+	EnvParameterStorePath     = "AWS_SSM_PATH"
+	EnvParameterStoreKmsKeyId = "AWS_SSM_KMS_KEY_ID"
+	EnvParameterStoreTier     = "AWS_SSM_TIER"
+)
+
+func (c *AwsParameterStoreFactory) Create(cfg interface{}) (secrets.SecretProvider, error) {
+	sec, ok := cfg.(*AwsParameterStoreConfig)
+	if !ok || sec == nil {
+		return nil, cloudy.ErrInvalidConfiguration
+	}
+	return NewParameterStore(context.Background(), *sec)
+}
+
+func (c *AwsParameterStoreFactory) FromEnv(env *cloudy.Environment) (interface{}, error) {
+	cfg := &AwsParameterStoreConfig{}
+	creds, err := GetAwsCredentialsFromEnv(env)
+	if err != nil {
+		return nil, err
+	}
+	cfg.AwsCredentials = creds
+
+	reader := envReader{env: env}
+	cfg.Path = reader.get(EnvParameterStorePath)
+	cfg.KmsKeyId = reader.get(EnvParameterStoreKmsKeyId)
+	cfg.Tier = reader.get(EnvParameterStoreTier)
+	return cfg, nil
+}
+
+// AwsParameterStore keeps secrets as SecureString parameters in SSM Parameter Store. Binary
+// secrets are stored base64 encoded.
+type AwsParameterStore struct {
+	AwsParameterStoreConfig
+	Client *ssm.Client
+}
+
+var _ secrets.SecretProvider = (*AwsParameterStore)(nil)
+
+func NewParameterStore(ctx context.Context, cfg AwsParameterStoreConfig) (*AwsParameterStore, error) {
+	cloudy.Info(ctx, "AWS ParameterStore: NewParameterStore")
+
+	ps := &AwsParameterStore{
+		AwsParameterStoreConfig: cfg,
+	}
+
+	err := ps.Configure(ctx)
+	return ps, err
+}
+
+func (a *AwsParameterStore) Configure(ctx context.Context) error {
+	switch ssmtypes.ParameterTier(a.Tier) {
+	case "", ssmtypes.ParameterTierStandard, ssmtypes.ParameterTierAdvanced, ssmtypes.ParameterTierIntelligentTiering:
+	default:
+		return fmt.Errorf("unknown parameter tier: %v", a.Tier)
+	}
+
+	cfg, err := NewAwsConfig(ctx, &a.AwsCredentials)
+	if err != nil {
+		return err
+	}
+	if err := validateOnConfigure(ctx, &a.AwsCredentials, cfg, ParameterStoreActions); err != nil {
+		return err
+	}
+
+	a.Client = ssm.NewFromConfig(cfg, func(o *ssm.Options) {
+		if url := a.AwsCredentials.EndpointFor(ssm.ServiceID); url != "" {
+			o.BaseEndpoint = aws.String(url)
+		}
+	})
+	return nil
+}
+
+// ListAll returns the key of every parameter under Path, at any depth
+func (a *AwsParameterStore) ListAll(ctx context.Context) ([]string, error) {
+	cloudy.Info(ctx, "AWS ParameterStore: ListAll")
+
+	root := a.root()
+	path := root
+	if path == "" {
+		path = "/"
+	}
+
+	var keys []string
+	paginator := ssm.NewGetParametersByPathPaginator(a.Client, &ssm.GetParametersByPathInput{
+		Path:      aws.String(path),
+		Recursive: aws.Bool(true),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, mapSecretError(ctx, "GetParametersByPath", path, err)
+		}
+		for _, param := range page.Parameters {
+			keys = append(keys, strings.TrimPrefix(aws.ToString(param.Name), root+"/"))
+		}
+	}
+
+	cloudy.Info(ctx, "AWS ParameterStore: listed %d parameters", len(keys))
+	return keys, nil
+}
+
+func (a *AwsParameterStore) SaveSecret(ctx context.Context, key string, secret string) error {
+	cloudy.Info(ctx, "saving parameter with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+	return a.putParameter(ctx, key, secret)
+}
+
+func (a *AwsParameterStore) SaveSecretBinary(ctx context.Context, key string, secret []byte) error {
+	cloudy.Info(ctx, "saving binary parameter with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+	return a.putParameter(ctx, key, base64.StdEncoding.EncodeToString(secret))
+}
+
+func (a *AwsParameterStore) GetSecret(ctx context.Context, key string) (string, error) {
+	cloudy.Info(ctx, "getting parameter with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+	return a.getParameter(ctx, key)
+}
+
+func (a *AwsParameterStore) GetSecretBinary(ctx context.Context, key string) ([]byte, error) {
+	cloudy.Info(ctx, "getting binary parameter with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+
+	value, err := a.getParameter(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("parameter %v is not base64: %w", key, err)
+	}
+	return data, nil
+}
+
+// DeleteSecret deletes a parameter, deleting one that does not exist is not an error
+func (a *AwsParameterStore) DeleteSecret(ctx context.Context, key string) error {
+	cloudy.Info(ctx, "deleting parameter with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+
+	name := a.parameterName(key)
+	_, err := a.Client.DeleteParameter(ctx, &ssm.DeleteParameterInput{
+		Name: aws.String(name),
+	})
+	err = mapSecretError(ctx, "DeleteParameter", name, err)
+	if errors.Is(err, ErrSecretNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (a *AwsParameterStore) getParameter(ctx context.Context, key string) (string, error) {
+	name := a.parameterName(key)
+	result, err := a.Client.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", mapSecretError(ctx, "GetParameter", name, err)
+	}
+	return aws.ToString(result.Parameter.Value), nil
+}
+
+// putParameter creates or overwrites a SecureString parameter
+func (a *AwsParameterStore) putParameter(ctx context.Context, key string, value string) error {
+	name := a.parameterName(key)
+	input := &ssm.PutParameterInput{
+		Name:      aws.String(name),
+		Value:     aws.String(value),
+		Type:      ssmtypes.ParameterTypeSecureString,
+		Overwrite: aws.Bool(true),
+		Tier:      ssmtypes.ParameterTier(a.Tier),
+	}
+	if a.KmsKeyId != "" {
+		input.KeyId = aws.String(a.KmsKeyId)
+	}
+
+	_, err := a.Client.PutParameter(ctx, input)
+	return mapSecretError(ctx, "PutParameter", name, err)
+}
+
+// parameterName is the full name of the parameter for a key, parameters in a hierarchy must
+// start with "/"
+func (a *AwsParameterStore) parameterName(key string) string {
+	return a.root() + "/" + strings.TrimPrefix(key, "/")
+}
+
+// root is Path as "/myapp/prod", or "" for the top of the hierarchy
+func (a *AwsParameterStore) root() string {
+	path := strings.Trim(a.Path, "/")
+	if path == "" {
+		return ""
+	}
+	return "/" + path
+}
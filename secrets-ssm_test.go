@@ -0,0 +1,270 @@
+package cloudyaws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/appliedres/cloudy"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeParameterStore is an in-memory SSM Parameter Store speaking the JSON protocol of the SDK
+type fakeParameterStore struct {
+	*httptest.Server
+	mu     sync.Mutex
+	params map[string]*fakeParameter
+}
+
+type fakeParameter struct {
+	Value string
+	Type  string
+	KeyId string
+	Tier  string
+}
+
+func newFakeParameterStore(t *testing.T) *fakeParameterStore {
+	stub := &fakeParameterStore{params: map[string]*fakeParameter{}}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&input)
+
+		handlers := map[string]func(map[string]interface{}) (interface{}, error){
+			"GetParameter":        stub.getParameter,
+			"PutParameter":        stub.putParameter,
+			"DeleteParameter":     stub.deleteParameter,
+			"GetParametersByPath": stub.getParametersByPath,
+		}
+		handler, ok := handlers[strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonSSM.")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		output, err := handler(input)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"__type": err.(*fakeSecretsError).Code, "message": err.Error()})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(output)
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+var errFakeParameterNotFound = &fakeSecretsError{"ParameterNotFound", "parameter not found"}
+
+func (stub *fakeParameterStore) getParameter(input map[string]interface{}) (interface{}, error) {
+	name := input["Name"].(string)
+	param, ok := stub.params[name]
+	if !ok {
+		return nil, errFakeParameterNotFound
+	}
+	value := param.Value
+	if decrypt, _ := input["WithDecryption"].(bool); !decrypt && param.Type == "SecureString" {
+		value = "encrypted"
+	}
+	return map[string]interface{}{
+		"Parameter": map[string]interface{}{"Name": name, "Type": param.Type, "Value": value},
+	}, nil
+}
+
+func (stub *fakeParameterStore) putParameter(input map[string]interface{}) (interface{}, error) {
+	name := input["Name"].(string)
+	if _, ok := stub.params[name]; ok {
+		if overwrite, _ := input["Overwrite"].(bool); !overwrite {
+			return nil, &fakeSecretsError{"ParameterAlreadyExists", "parameter already exists"}
+		}
+	}
+	param := &fakeParameter{Value: input["Value"].(string), Type: input["Type"].(string), Tier: "Standard"}
+	param.KeyId, _ = input["KeyId"].(string)
+	if tier, ok := input["Tier"].(string); ok {
+		param.Tier = tier
+	}
+	if param.Tier == "Standard" && len(param.Value) > 4096 {
+		return nil, &fakeSecretsError{"ValidationException", "value is too large for the Standard tier"}
+	}
+	stub.params[name] = param
+	return map[string]interface{}{"Version": 1, "Tier": param.Tier}, nil
+}
+
+func (stub *fakeParameterStore) deleteParameter(input map[string]interface{}) (interface{}, error) {
+	name := input["Name"].(string)
+	if _, ok := stub.params[name]; !ok {
+		return nil, errFakeParameterNotFound
+	}
+	delete(stub.params, name)
+	return map[string]interface{}{}, nil
+}
+
+// getParametersByPath returns two parameters a page so the paginator is exercised
+func (stub *fakeParameterStore) getParametersByPath(input map[string]interface{}) (interface{}, error) {
+	path := strings.TrimSuffix(input["Path"].(string), "/") + "/"
+	recursive, _ := input["Recursive"].(bool)
+
+	var names []string
+	for name := range stub.params {
+		if !strings.HasPrefix(name, path) {
+			continue
+		}
+		if !recursive && strings.Contains(strings.TrimPrefix(name, path), "/") {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	start := 0
+	if token, ok := input["NextToken"].(string); ok {
+		start, _ = strconv.Atoi(token)
+	}
+	end := min(start+2, len(names))
+	output := map[string]interface{}{}
+	var params []map[string]interface{}
+	for _, name := range names[start:end] {
+		params = append(params, map[string]interface{}{"Name": name, "Type": stub.params[name].Type, "Value": "encrypted"})
+	}
+	output["Parameters"] = params
+	if end < len(names) {
+		output["NextToken"] = strconv.Itoa(end)
+	}
+	return output, nil
+}
+
+func newFakeParameterStoreProvider(t *testing.T, configure ...func(*AwsParameterStoreConfig)) (*AwsParameterStore, *fakeParameterStore) {
+	isolateAwsEnv(t)
+	stub := newFakeParameterStore(t)
+
+	cfg := AwsParameterStoreConfig{
+		AwsCredentials: AwsCredentials{
+			Type:            CredTypeSecret,
+			Region:          "us-east-1",
+			AccessKeyID:     "AKID",
+			SecretAccessKey: "secret",
+			Endpoints:       map[string]string{"ssm": stub.URL},
+		},
+	}
+	for _, fn := range configure {
+		fn(&cfg)
+	}
+
+	ps, err := NewParameterStore(context.Background(), cfg)
+	assert.Nil(t, err)
+	return ps, stub
+}
+
+func TestParameterStore(t *testing.T) {
+	ctx := context.Background()
+	ps, stub := newFakeParameterStoreProvider(t)
+
+	assert.Nil(t, ps.SaveSecret(ctx, "app/db", "password"))
+	assert.Nil(t, ps.SaveSecret(ctx, "/app/db", "new-password"))
+	assert.Nil(t, ps.SaveSecret(ctx, "token", "abc"))
+	assert.Nil(t, ps.SaveSecretBinary(ctx, "app/certs/key", []byte{0, 1, 2, 255}))
+
+	assert.Equal(t, "SecureString", stub.params["/app/db"].Type)
+	assert.Equal(t, "AAEC/w==", stub.params["/app/certs/key"].Value)
+
+	secret, err := ps.GetSecret(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, "new-password", secret)
+
+	data, err := ps.GetSecretBinary(ctx, "app/certs/key")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0, 1, 2, 255}, data)
+
+	_, err = ps.GetSecretBinary(ctx, "app/db")
+	assert.NotNil(t, err)
+
+	keys, err := ps.ListAll(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"app/certs/key", "app/db", "token"}, keys)
+
+	_, err = ps.GetSecret(ctx, "missing")
+	assert.True(t, errors.Is(err, ErrSecretNotFound))
+
+	assert.Nil(t, ps.DeleteSecret(ctx, "app/db"))
+	assert.Nil(t, ps.DeleteSecret(ctx, "app/db"))
+	_, err = ps.GetSecret(ctx, "app/db")
+	assert.True(t, errors.Is(err, ErrSecretNotFound))
+}
+
+func TestParameterStorePathAndTier(t *testing.T) {
+	ctx := context.Background()
+	ps, stub := newFakeParameterStoreProvider(t, func(cfg *AwsParameterStoreConfig) {
+		cfg.Path = "myapp/prod/"
+		cfg.KmsKeyId = "alias/myapp"
+		cfg.Tier = "Advanced"
+	})
+	stub.params["/myapp/dev/db"] = &fakeParameter{Value: "dev", Type: "SecureString"}
+	stub.params["/myapp/prodution"] = &fakeParameter{Value: "x", Type: "String"}
+
+	large := strings.Repeat("x", 6000)
+	assert.Nil(t, ps.SaveSecret(ctx, "db", large))
+	assert.Nil(t, ps.SaveSecret(ctx, "api/key", "key"))
+
+	param := stub.params["/myapp/prod/db"]
+	assert.Equal(t, large, param.Value)
+	assert.Equal(t, "alias/myapp", param.KeyId)
+	assert.Equal(t, "Advanced", param.Tier)
+
+	keys, err := ps.ListAll(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"api/key", "db"}, keys)
+
+	// Standard parameters are limited to 4 KB
+	standard, err := NewParameterStore(ctx, AwsParameterStoreConfig{AwsCredentials: ps.AwsCredentials})
+	assert.Nil(t, err)
+	assert.NotNil(t, standard.SaveSecret(ctx, "big", large))
+
+	_, err = NewParameterStore(ctx, AwsParameterStoreConfig{AwsCredentials: ps.AwsCredentials, Tier: "Premium"})
+	assert.NotNil(t, err)
+}
+
+func TestParameterStoreFactory(t *testing.T) {
+	isolateAwsEnv(t)
+	stub := newFakeParameterStore(t)
+
+	factory := &AwsParameterStoreFactory{}
+
+	cfg, err := factory.FromEnv(newTestEnv(map[string]string{
+		"AWS_ACCESS_KEY_ID":     "AKID",
+		"AWS_SECRET_ACCESS_KEY": "secret",
+		"AWS_REGION":            "us-east-1",
+		"AWS_SSM_PATH":          "/myapp",
+		"AWS_SSM_KMS_KEY_ID":    "alias/myapp",
+		"AWS_SSM_TIER":          "Intelligent-Tiering",
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, "/myapp", cfg.(*AwsParameterStoreConfig).Path)
+	assert.Equal(t, "alias/myapp", cfg.(*AwsParameterStoreConfig).KmsKeyId)
+	assert.Equal(t, "Intelligent-Tiering", cfg.(*AwsParameterStoreConfig).Tier)
+
+	_, err = factory.Create(&AwsSecretManagerConfig{})
+	assert.Equal(t, cloudy.ErrInvalidConfiguration, err)
+
+	provider, err := factory.Create(&AwsParameterStoreConfig{
+		AwsCredentials: AwsCredentials{
+			Type:            CredTypeSecret,
+			Region:          "us-east-1",
+			AccessKeyID:     "AKID",
+			SecretAccessKey: "secret",
+			Endpoints:       map[string]string{"ssm": stub.URL},
+		},
+		Path: "/factory",
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, provider.SaveSecret(context.Background(), "db", "password"))
+	assert.Equal(t, "password", stub.params["/factory/db"].Value)
+}
@@ -1,18 +1,20 @@
 package cloudyaws
 
-// TODO: implement back off and retry for creating/getting deleted secrets, as they can take up to 2 hours to delete
-
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
 
 	"github.com/appliedres/cloudy"
 	"github.com/appliedres/cloudy/secrets"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/secretsmanager"
-	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
 )
 
 const AwsSecretManagerID = "aws"
@@ -25,37 +27,110 @@ type AwsSecretManagerFactory struct{}
 
 type AwsSecretManagerConfig struct {
 	AwsCredentials
+
+	// Days a deleted secret can still be restored, 7 to 30. 0 uses DefaultRecoveryWindowDays.
+	RecoveryWindowDays int
+	// Delete without a recovery window, the secret cannot be restored
+	ForceDelete bool
+	// What SaveSecret does when the secret is scheduled for deletion, DeletedSecretRestore by default
+	DeletedSecretPolicy string
+	// Create attempts made by DeletedSecretRetry, 0 uses DefaultDeletedSecretRetries
+	DeletedSecretRetries int
+	// KMS key for secrets created without one in their SecretOptions, the AWS managed
+	// aws/secretsmanager key when empty
+	KmsKeyId string
+	// Providers created by the factory cache reads for this long, 0 does not cache
+	CacheTTL time.Duration
+	// Parallel reads by GetSecrets when the batch API is not available, 0 uses DefaultSecretWorkers
+	Workers int
+	// Path every key is relative to, e.g. "prod/tenantA". Keys cannot reach outside it.
+	Namespace string
 }
 
+// Values for AwsSecretManagerConfig.DeletedSecretPolicy
+const (
+	// Restore the deleted secret and write the new value as its current version
+	DeletedSecretRestore = "restore"
+	// Retry the create with backoff until the deletion has completed
+	DeletedSecretRetry = "retry"
+	// Return the error
+	DeletedSecretFail = "fail"
+)
+
+const (
+	DefaultRecoveryWindowDays   = 30
+	DefaultDeletedSecretRetries = 8
+)
+
+// Environment variables read by the factory on top of the credentials
+const (
+	EnvSecretsRecoveryWindowDays = "AWS_SECRETS_RECOVERY_WINDOW_DAYS"
+	EnvSecretsForceDelete        = "AWS_SECRETS_FORCE_DELETE"
+	EnvSecretsDeletedPolicy      = "AWS_SECRETS_DELETED_POLICY"
+	EnvSecretsKmsKeyId           = "AWS_SECRETS_KMS_KEY_ID"
+	EnvSecretsCacheTTLSeconds    = "AWS_SECRETS_CACHE_TTL_SECONDS"
+	EnvSecretsNamespace          = "AWS_SECRETS_NAMESPACE"
+)
+
 func (c *AwsSecretManagerFactory) Create(cfg interface{}) (secrets.SecretProvider, error) {
 	fmt.Println("AWS SecretManager: Create")
 
-	sec := cfg.(*AwsSecretManager)
-	if sec == nil {
+	sec, ok := cfg.(*AwsSecretManagerConfig)
+	if !ok || sec == nil {
 		return nil, cloudy.ErrInvalidConfiguration
 	}
-	return NewSecretManager(context.Background(), sec.AwsCredentials)
+	sm, err := NewSecretManagerFromConfig(context.Background(), *sec)
+	if err != nil || sec.CacheTTL <= 0 {
+		return sm, err
+	}
+	return NewCachedSecretManager(sm, SecretCacheConfig{TTL: sec.CacheTTL}), nil
 }
 
 func (c *AwsSecretManagerFactory) FromEnv(env *cloudy.Environment) (interface{}, error) {
 	fmt.Println("AWS SecretManager: FromEnv")
 
-	cfg := &AwsSecretManager{}
-	cfg.AwsCredentials = GetAwsCredentialsFromEnv(env)
+	cfg := &AwsSecretManagerConfig{}
+	creds, err := GetAwsCredentialsFromEnv(env)
+	if err != nil {
+		return nil, err
+	}
+	cfg.AwsCredentials = creds
+
+	reader := envReader{env: env}
+	if err := reader.int(EnvSecretsRecoveryWindowDays, &cfg.RecoveryWindowDays); err != nil {
+		return nil, err
+	}
+	if err := reader.bool(EnvSecretsForceDelete, &cfg.ForceDelete); err != nil {
+		return nil, err
+	}
+	cfg.DeletedSecretPolicy = reader.get(EnvSecretsDeletedPolicy)
+	cfg.KmsKeyId = reader.get(EnvSecretsKmsKeyId)
+	cfg.Namespace = reader.get(EnvSecretsNamespace)
+
+	var ttl int
+	if err := reader.int(EnvSecretsCacheTTLSeconds, &ttl); err != nil {
+		return nil, err
+	}
+	cfg.CacheTTL = time.Duration(ttl) * time.Second
 	return cfg, nil
 }
 
 type AwsSecretManager struct {
-	AwsCredentials
-	Client		*secretsmanager.SecretsManager
-}
+	AwsSecretManagerConfig
+	Client *secretsmanager.Client
 
+	batchUnsupported atomic.Bool // Set once the endpoint rejected BatchGetSecretValue
+}
 
 func NewSecretManager(ctx context.Context, creds AwsCredentials) (*AwsSecretManager, error) {
+	return NewSecretManagerFromConfig(ctx, AwsSecretManagerConfig{AwsCredentials: creds})
+}
+
+func NewSecretManagerFromConfig(ctx context.Context, cfg AwsSecretManagerConfig) (*AwsSecretManager, error) {
 	cloudy.Info(ctx, "AWS SecretManager: NewSecretManager")
 
 	sm := &AwsSecretManager{
-		AwsCredentials: creds,
+		AwsSecretManagerConfig: cfg,
 	}
 
 	err := sm.Configure(ctx)
@@ -64,330 +139,655 @@ func NewSecretManager(ctx context.Context, creds AwsCredentials) (*AwsSecretMana
 }
 
 func (a *AwsSecretManager) Configure(ctx context.Context) error {
-    sess, err := session.NewSessionWithOptions(session.Options{
-        Config: aws.Config{
-			Credentials: credentials.NewStaticCredentials(
-				a.AwsCredentials.AccessKeyID,
-				a.AwsCredentials.SecretAccessKey,
-				"",
-			),
-			Region:      aws.String(a.AwsCredentials.Region),
-        },
-    })
+	if a.RecoveryWindowDays != 0 && (a.RecoveryWindowDays < 7 || a.RecoveryWindowDays > 30) {
+		return fmt.Errorf("recovery window must be 7 to 30 days, not %v", a.RecoveryWindowDays)
+	}
+	switch a.DeletedSecretPolicy {
+	case "", DeletedSecretRestore, DeletedSecretRetry, DeletedSecretFail:
+	default:
+		return fmt.Errorf("unknown deleted secret policy: %v", a.DeletedSecretPolicy)
+	}
+
+	cfg, err := NewAwsConfig(ctx, &a.AwsCredentials)
 	if err != nil {
 		return err
 	}
+	if err := validateOnConfigure(ctx, &a.AwsCredentials, cfg, SecretManagerActions); err != nil {
+		return err
+	}
 
-	client := secretsmanager.New(sess)
-
-	a.Client = client
+	a.Client = secretsmanager.NewFromConfig(cfg, func(o *secretsmanager.Options) {
+		if url := a.AwsCredentials.EndpointFor(secretsmanager.ServiceID); url != "" {
+			o.BaseEndpoint = aws.String(url)
+		}
+	})
 	return nil
 }
 
-func (a *AwsSecretManager) ListAll(ctx context.Context) ([]string, error) {	
-	cloudy.Info(ctx, "AWS SecretManager: ListAll")
+// Staging labels Secrets Manager manages itself
+const (
+	StageCurrent  = "AWSCURRENT"
+	StagePrevious = "AWSPREVIOUS"
+	StagePending  = "AWSPENDING"
+)
 
-	// Set the input parameters for listing secrets
-	input := &secretsmanager.ListSecretsInput{}
+// SecretValue is one version of a secret, only one of String and Binary is set
+type SecretValue struct {
+	VersionId   string
+	Stages      []string
+	CreatedDate time.Time
+	String      string
+	Binary      []byte
+}
 
-	// Call the ListSecrets API
-	result, err := a.Client.ListSecrets(input)
-	if err != nil {
-		// Handle errors
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case secretsmanager.ErrCodeInvalidNextTokenException:
-				cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeInvalidNextTokenException, aerr.Error())
-			case secretsmanager.ErrCodeInvalidParameterException:
-				cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeInvalidParameterException, aerr.Error())
-			case secretsmanager.ErrCodeResourceNotFoundException:
-				cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeResourceNotFoundException, aerr.Error())
-			default:
-				cloudy.Info(ctx, aerr.Error())
+// SecretVersion describes a version of a secret without its value
+type SecretVersion struct {
+	VersionId        string
+	Stages           []string
+	CreatedDate      time.Time
+	LastAccessedDate time.Time
+}
+
+// SecretFilter narrows a secret listing on the server side, empty fields are not applied.
+// Name and Description match by prefix.
+type SecretFilter struct {
+	NamePrefix  string
+	Description string
+	TagKey      string
+	TagValue    string
+}
+
+// SecretPage is one page of a secret listing
+type SecretPage struct {
+	Names     []string
+	NextToken string // Pass to ListSecretsPage for the next page, "" on the last page
+}
+
+func (a *AwsSecretManager) ListAll(ctx context.Context) ([]string, error) {
+	return a.ListSecrets(ctx, SecretFilter{})
+}
+
+// ListSecrets returns the names of every secret matching the filter, following all pages.
+// With a namespace only its secrets are listed, by key.
+func (a *AwsSecretManager) ListSecrets(ctx context.Context, filter SecretFilter) ([]string, error) {
+	cloudy.Info(ctx, "AWS SecretManager: ListSecrets")
+
+	var secretNames []string
+	paginator := secretsmanager.NewListSecretsPaginator(a.Client, &secretsmanager.ListSecretsInput{
+		Filters: a.scopeFilter(filter).toFilters(),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, mapSecretError(ctx, "ListSecrets", "", err)
+		}
+		for _, secret := range page.SecretList {
+			if key, ok := a.secretKey(aws.ToString(secret.Name)); ok {
+				secretNames = append(secretNames, key)
 			}
-		} else {
-			cloudy.Info(ctx, err.Error())
 		}
-		return nil, err
 	}
 
-	// Print the list of secrets
-	cloudy.Info(ctx, "Secrets:")
-	var secretNames []string
+	cloudy.Info(ctx, "AWS SecretManager: listed %d secrets", len(secretNames))
+	return secretNames, nil
+}
+
+// ListSecretsPage returns a single page of up to pageSize (1-100) secret names. An empty
+// token starts from the beginning, pageSize 0 uses the service default.
+func (a *AwsSecretManager) ListSecretsPage(ctx context.Context, filter SecretFilter, token string, pageSize int) (*SecretPage, error) {
+	input := &secretsmanager.ListSecretsInput{
+		Filters: a.scopeFilter(filter).toFilters(),
+	}
+	if token != "" {
+		input.NextToken = aws.String(token)
+	}
+	if pageSize > 0 {
+		input.MaxResults = aws.Int32(int32(pageSize))
+	}
+
+	result, err := a.Client.ListSecrets(ctx, input)
+	if err != nil {
+		return nil, mapSecretError(ctx, "ListSecrets", "", err)
+	}
+
+	page := &SecretPage{
+		NextToken: aws.ToString(result.NextToken),
+	}
 	for _, secret := range result.SecretList {
-		cloudy.Info(ctx, *secret.Name)
-		secretNames = append(secretNames, *secret.Name)
+		if key, ok := a.secretKey(aws.ToString(secret.Name)); ok {
+			page.Names = append(page.Names, key)
+		}
 	}
-	return secretNames, err
+	return page, nil
 }
 
+func (filter SecretFilter) toFilters() []smtypes.Filter {
+	var filters []smtypes.Filter
+	add := func(key smtypes.FilterNameStringType, value string) {
+		if value != "" {
+			filters = append(filters, smtypes.Filter{
+				Key:    key,
+				Values: []string{value},
+			})
+		}
+	}
+	add(smtypes.FilterNameStringTypeName, filter.NamePrefix)
+	add(smtypes.FilterNameStringTypeDescription, filter.Description)
+	add(smtypes.FilterNameStringTypeTagKey, filter.TagKey)
+	add(smtypes.FilterNameStringTypeTagValue, filter.TagValue)
+	return filters
+}
 
 func (a *AwsSecretManager) SaveSecret(ctx context.Context, key string, secret string) error {
 	cloudy.Info(ctx, "saving raw secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
 
-	err := a.createSecretRaw(ctx, key, secret)
+	key, err := a.secretName(key)
 	if err != nil {
-		err = a.putSecretRaw(ctx, key, secret)
-		if err != nil {
-			return err
-		}
-		cloudy.Info(ctx, "successfully put raw secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
-		return nil
+		return err
 	}
 
-	cloudy.Info(ctx, "successfully created raw secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
-	return nil
+	_, err = a.saveSecret(ctx, key, &SecretValue{String: secret}, SaveOptions{})
+	return err
 }
 
 func (a *AwsSecretManager) SaveSecretBinary(ctx context.Context, key string, secret []byte) error {
 	cloudy.Info(ctx, "saving binary secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
 
-	err := a.createSecretBinary(ctx, key, secret)
+	key, err := a.secretName(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.saveSecret(ctx, key, &SecretValue{Binary: secret}, SaveOptions{})
+	return err
+}
+
+// SaveOptions makes a save conditional and idempotent
+type SaveOptions struct {
+	// Only write if this is the current version, otherwise fail with ErrVersionConflict.
+	// Empty writes unconditionally.
+	ExpectedVersionId string
+	// Idempotency token, it becomes the VersionId of the new version so retrying with the
+	// same token does not create a second version. Generated by the SDK when empty.
+	ClientRequestToken string
+	// Applied when the save creates the secret, an existing secret keeps its settings
+	Secret SecretOptions
+}
+
+// SaveSecretWithOptions creates the secret or writes a new current version, optionally as a
+// compare-and-swap against ExpectedVersionId. It returns the VersionId written.
+func (a *AwsSecretManager) SaveSecretWithOptions(ctx context.Context, key string, value *SecretValue, opts SaveOptions) (string, error) {
+	cloudy.Info(ctx, "saving secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+
+	key, err := a.secretName(key)
 	if err != nil {
-		err = a.putSecretBinary(ctx, key, secret)
+		return "", err
+	}
+
+	return a.saveSecret(ctx, key, value, opts)
+}
+
+// saveSecret is the upsert behind every save, it only falls back to a put when the create
+// failed because the secret exists
+func (a *AwsSecretManager) saveSecret(ctx context.Context, key string, value *SecretValue, opts SaveOptions) (string, error) {
+	if opts.ExpectedVersionId != "" {
+		return a.compareAndSwap(ctx, key, value, opts)
+	}
+
+	versionId, err := a.createSecretValue(ctx, key, value, opts)
+	switch {
+	case err == nil:
+		cloudy.Info(ctx, "successfully created secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+		return versionId, nil
+	case errors.Is(err, ErrSecretExists):
+		versionId, err = a.putSecretValue(ctx, key, value, opts)
 		if err != nil {
-			return err
+			return "", err
 		}
-		cloudy.Info(ctx, "successfully put binary secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
-		return nil
+		cloudy.Info(ctx, "successfully put secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+		return versionId, nil
+	case errors.Is(err, ErrSecretPendingDeletion):
+		return a.recreateDeletedSecret(ctx, key, err, value, opts)
+	default:
+		return "", err
+	}
+}
+
+// compareAndSwap writes the value as a new version under a private staging label and then
+// moves AWSCURRENT onto it. The move names the expected version as the one to take the label
+// from, which Secrets Manager rejects if another writer has moved AWSCURRENT in between.
+func (a *AwsSecretManager) compareAndSwap(ctx context.Context, key string, value *SecretValue, opts SaveOptions) (string, error) {
+	current, err := a.versionWithStage(ctx, key, StageCurrent)
+	if err != nil {
+		return "", err
+	}
+	if opts.ClientRequestToken != "" && current == opts.ClientRequestToken {
+		// A retry of a swap that already went through
+		return current, nil
+	}
+	if current != opts.ExpectedVersionId {
+		return "", versionConflict(key, opts.ExpectedVersionId, current)
 	}
 
-	cloudy.Info(ctx, "successfully created binary secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
-	return nil
+	label, err := casStageLabel()
+	if err != nil {
+		return "", err
+	}
+	staged := *value
+	staged.Stages = []string{label}
+	versionId, err := a.putSecretValue(ctx, key, &staged, opts)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_, err = a.Client.UpdateSecretVersionStage(ctx, &secretsmanager.UpdateSecretVersionStageInput{
+			SecretId:            aws.String(key),
+			VersionStage:        aws.String(label),
+			RemoveFromVersionId: aws.String(versionId),
+		})
+		_ = mapSecretError(ctx, "UpdateSecretVersionStage", key, err)
+	}()
+
+	_, err = a.Client.UpdateSecretVersionStage(ctx, &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:            aws.String(key),
+		VersionStage:        aws.String(StageCurrent),
+		MoveToVersionId:     aws.String(versionId),
+		RemoveFromVersionId: aws.String(opts.ExpectedVersionId),
+	})
+	if err != nil {
+		if now, cerr := a.versionWithStage(ctx, key, StageCurrent); cerr == nil && now != opts.ExpectedVersionId {
+			return "", versionConflict(key, opts.ExpectedVersionId, now)
+		}
+		return "", mapSecretError(ctx, "UpdateSecretVersionStage", key, err)
+	}
+	return versionId, nil
+}
+
+// casStageLabel is a staging label unique to one compare-and-swap
+func casStageLabel() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "CLOUDY_CAS_" + hex.EncodeToString(b), nil
 }
 
 func (a *AwsSecretManager) GetSecret(ctx context.Context, key string) (string, error) {
 	cloudy.Info(ctx, "getting raw secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
-	str, _, err := a.getRawSecret(ctx, key)
-	return str, err
+
+	key, err := a.secretName(key)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := a.getRawSecret(ctx, key, "", "")
+	if err != nil {
+		return "", err
+	}
+	return value.String, nil
 }
 
 func (a *AwsSecretManager) GetSecretBinary(ctx context.Context, key string) ([]byte, error) {
 	cloudy.Info(ctx, "getting binary secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
-	_, data, err := a.getRawSecret(ctx, key)
-	return data, err
+
+	key, err := a.secretName(key)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := a.getRawSecret(ctx, key, "", "")
+	if err != nil {
+		return nil, err
+	}
+	return value.Binary, nil
 }
 
-func (a *AwsSecretManager) DeleteSecret(ctx context.Context, key string) error {
-	cloudy.Info(ctx, "deleting secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+// GetSecretVersion reads a specific version of a secret
+func (a *AwsSecretManager) GetSecretVersion(ctx context.Context, key string, versionId string) (*SecretValue, error) {
+	cloudy.Info(ctx, "getting secret with key [%s] version [%s] in region [%s]", key, versionId, a.AwsCredentials.Region)
 
-	_, err := a.Client.DeleteSecret(&secretsmanager.DeleteSecretInput{
-		SecretId: aws.String(key),
-		ForceDeleteWithoutRecovery: aws.Bool(true),
+	key, err := a.secretName(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.getRawSecret(ctx, key, versionId, "")
+}
 
+// GetSecretStage reads the version of a secret that holds a staging label, e.g. StagePrevious
+func (a *AwsSecretManager) GetSecretStage(ctx context.Context, key string, stage string) (*SecretValue, error) {
+	cloudy.Info(ctx, "getting secret with key [%s] stage [%s] in region [%s]", key, stage, a.AwsCredentials.Region)
+
+	key, err := a.secretName(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.getRawSecret(ctx, key, "", stage)
+}
+
+// SaveSecretVersion writes a new version of an existing secret with the given staging
+// labels, AWSCURRENT when none are given, and returns its VersionId. Writing with
+// StagePending stages a value without making it current.
+func (a *AwsSecretManager) SaveSecretVersion(ctx context.Context, key string, value *SecretValue) (string, error) {
+	cloudy.Info(ctx, "saving secret version with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+
+	key, err := a.secretName(key)
+	if err != nil {
+		return "", err
+	}
+
+	return a.putSecretValue(ctx, key, value, SaveOptions{})
+}
+
+// ListSecretVersions returns the version history of a secret, newest first. Versions without
+// a staging label are only returned when includeDeprecated is set.
+func (a *AwsSecretManager) ListSecretVersions(ctx context.Context, key string, includeDeprecated bool) ([]*SecretVersion, error) {
+	cloudy.Info(ctx, "listing versions of secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+
+	key, err := a.secretName(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []*SecretVersion
+	paginator := secretsmanager.NewListSecretVersionIdsPaginator(a.Client, &secretsmanager.ListSecretVersionIdsInput{
+		SecretId:          aws.String(key),
+		IncludeDeprecated: aws.Bool(includeDeprecated),
 	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, mapSecretError(ctx, "ListSecretVersionIds", key, err)
+		}
+		for _, v := range page.Versions {
+			versions = append(versions, &SecretVersion{
+				VersionId:        aws.ToString(v.VersionId),
+				Stages:           v.VersionStages,
+				CreatedDate:      aws.ToTime(v.CreatedDate),
+				LastAccessedDate: aws.ToTime(v.LastAccessedDate),
+			})
+		}
+	}
 
-	return err
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].CreatedDate.After(versions[j].CreatedDate)
+	})
+	return versions, nil
 }
 
-func (a *AwsSecretManager) putSecretRaw(ctx context.Context, key string, secret string) error {
-	cloudy.Info(ctx, "putting raw secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
-	
-	input := &secretsmanager.PutSecretValueInput{
-		SecretId:     aws.String(key),
-		SecretString: aws.String(secret),
-	}
-
-	_, err := a.Client.PutSecretValue(input)
-    if err != nil {
-        // Handle errors using awserr.
-        if aerr, ok := err.(awserr.Error); ok {
-            switch aerr.Code() {
-            case secretsmanager.ErrCodeInvalidParameterException:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeInvalidParameterException, aerr.Error())
-            case secretsmanager.ErrCodeInvalidRequestException:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeInvalidRequestException, aerr.Error())
-            case secretsmanager.ErrCodeLimitExceededException:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeLimitExceededException, aerr.Error())
-            case secretsmanager.ErrCodeEncryptionFailure:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeEncryptionFailure, aerr.Error())
-            case secretsmanager.ErrCodeResourceExistsException:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeResourceExistsException, aerr.Error())
-            case secretsmanager.ErrCodeMalformedPolicyDocumentException:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeMalformedPolicyDocumentException, aerr.Error())
-            case secretsmanager.ErrCodeInternalServiceError:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeInternalServiceError, aerr.Error())
-            default:
-                cloudy.Info(ctx, aerr.Error())
-            }
-        } else {
-            cloudy.Info(ctx, err.Error())
-        }
-        return err
-    }
+// MoveSecretStage attaches a staging label to a version, taking it off the version that
+// holds it now. Moving StageCurrent makes AWS label the old current version StagePrevious.
+func (a *AwsSecretManager) MoveSecretStage(ctx context.Context, key string, stage string, versionId string) error {
+	cloudy.Info(ctx, "moving stage [%s] of secret with key [%s] to version [%s]", stage, key, versionId)
 
-	return nil
+	name, err := a.secretName(key)
+	if err != nil {
+		return err
+	}
+	return a.moveSecretStage(ctx, name, stage, versionId)
 }
 
-func (a *AwsSecretManager) putSecretBinary(ctx context.Context, key string, secret []byte) error {
-	cloudy.Info(ctx, "putting raw secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+func (a *AwsSecretManager) moveSecretStage(ctx context.Context, key string, stage string, versionId string) error {
+	holder, err := a.versionWithStage(ctx, key, stage)
+	if err != nil {
+		return err
+	}
+	if holder == versionId {
+		return nil
+	}
 
-	input := &secretsmanager.PutSecretValueInput{
-		SecretId:     aws.String(key),
-		SecretBinary: secret,
-	}
-
-	_, err := a.Client.PutSecretValue(input)
-    if err != nil {
-        // Handle errors using awserr.
-        if aerr, ok := err.(awserr.Error); ok {
-            switch aerr.Code() {
-            case secretsmanager.ErrCodeInvalidParameterException:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeInvalidParameterException, aerr.Error())
-            case secretsmanager.ErrCodeInvalidRequestException:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeInvalidRequestException, aerr.Error())
-            case secretsmanager.ErrCodeLimitExceededException:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeLimitExceededException, aerr.Error())
-            case secretsmanager.ErrCodeEncryptionFailure:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeEncryptionFailure, aerr.Error())
-            case secretsmanager.ErrCodeResourceExistsException:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeResourceExistsException, aerr.Error())
-            case secretsmanager.ErrCodeMalformedPolicyDocumentException:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeMalformedPolicyDocumentException, aerr.Error())
-            case secretsmanager.ErrCodeInternalServiceError:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeInternalServiceError, aerr.Error())
-            default:
-                cloudy.Info(ctx, aerr.Error())
-            }
-        } else {
-            cloudy.Info(ctx, err.Error())
-        }
-        return err
-    }
+	input := &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:        aws.String(key),
+		VersionStage:    aws.String(stage),
+		MoveToVersionId: aws.String(versionId),
+	}
+	if holder != "" {
+		input.RemoveFromVersionId = aws.String(holder)
+	}
+	_, err = a.Client.UpdateSecretVersionStage(ctx, input)
+	return mapSecretError(ctx, "UpdateSecretVersionStage", key, err)
+}
 
-	return nil
+// PromoteSecretVersion makes a version current, e.g. after staging it with StagePending
+func (a *AwsSecretManager) PromoteSecretVersion(ctx context.Context, key string, versionId string) error {
+	return a.MoveSecretStage(ctx, key, StageCurrent, versionId)
 }
 
-func (a *AwsSecretManager) createSecretRaw(ctx context.Context, key string, secret string) error {
-	cloudy.Info(ctx, "creating raw secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
-	
-	input := &secretsmanager.CreateSecretInput{
-		Name:     aws.String(key),
-		SecretString: aws.String(secret),
-	}
-
-	_, err := a.Client.CreateSecret(input)
-    if err != nil {
-        // Handle errors using awserr.
-        if aerr, ok := err.(awserr.Error); ok {
-            switch aerr.Code() {
-            case secretsmanager.ErrCodeInvalidParameterException:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeInvalidParameterException, aerr.Error())
-            case secretsmanager.ErrCodeInvalidRequestException:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeInvalidRequestException, aerr.Error())
-            case secretsmanager.ErrCodeLimitExceededException:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeLimitExceededException, aerr.Error())
-            case secretsmanager.ErrCodeEncryptionFailure:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeEncryptionFailure, aerr.Error())
-            case secretsmanager.ErrCodeResourceExistsException:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeResourceExistsException, aerr.Error())
-            case secretsmanager.ErrCodeMalformedPolicyDocumentException:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeMalformedPolicyDocumentException, aerr.Error())
-            case secretsmanager.ErrCodeInternalServiceError:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeInternalServiceError, aerr.Error())
-            default:
-                cloudy.Info(ctx, aerr.Error())
-            }
-        } else {
-            cloudy.Info(ctx, err.Error())
-        }
-        return err
-    }
+// RollbackSecret makes the previous version current again
+func (a *AwsSecretManager) RollbackSecret(ctx context.Context, key string) error {
+	key, err := a.secretName(key)
+	if err != nil {
+		return err
+	}
 
-	return nil
+	previous, err := a.versionWithStage(ctx, key, StagePrevious)
+	if err != nil {
+		return err
+	}
+	if previous == "" {
+		return fmt.Errorf("secret %v has no %v version to roll back to", key, StagePrevious)
+	}
+	return a.moveSecretStage(ctx, key, StageCurrent, previous)
 }
 
-func (a *AwsSecretManager) createSecretBinary(ctx context.Context, key string, secret []byte) error {
-	cloudy.Info(ctx, "creating raw secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
-
-	input := &secretsmanager.CreateSecretInput{
-		Name:     aws.String(key),
-		SecretBinary: secret,
-	}
-
-	_, err := a.Client.CreateSecret(input)
-    if err != nil {
-        // Handle errors using awserr.
-        if aerr, ok := err.(awserr.Error); ok {
-            switch aerr.Code() {
-            case secretsmanager.ErrCodeInvalidParameterException:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeInvalidParameterException, aerr.Error())
-            case secretsmanager.ErrCodeInvalidRequestException:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeInvalidRequestException, aerr.Error())
-            case secretsmanager.ErrCodeLimitExceededException:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeLimitExceededException, aerr.Error())
-            case secretsmanager.ErrCodeEncryptionFailure:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeEncryptionFailure, aerr.Error())
-            case secretsmanager.ErrCodeResourceExistsException:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeResourceExistsException, aerr.Error())
-            case secretsmanager.ErrCodeMalformedPolicyDocumentException:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeMalformedPolicyDocumentException, aerr.Error())
-            case secretsmanager.ErrCodeInternalServiceError:
-                cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeInternalServiceError, aerr.Error())
-            default:
-                cloudy.Info(ctx, aerr.Error())
-            }
-        } else {
-            cloudy.Info(ctx, err.Error())
-        }
-        return err
-    }
+// versionWithStage returns the version holding a staging label, "" when none does
+func (a *AwsSecretManager) versionWithStage(ctx context.Context, key string, stage string) (string, error) {
+	result, err := a.Client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{
+		SecretId: aws.String(key),
+	})
+	if err != nil {
+		return "", mapSecretError(ctx, "DescribeSecret", key, err)
+	}
 
-	return nil
+	for versionId, stages := range result.VersionIdsToStages {
+		for _, s := range stages {
+			if s == stage {
+				return versionId, nil
+			}
+		}
+	}
+	return "", nil
 }
 
-func (a *AwsSecretManager) getRawSecret(ctx context.Context, key string) (string, []byte, error) {
-	cloudy.Info(ctx, "getRawSecret")
+// DeleteSecret schedules the secret for deletion after the recovery window, or deletes it
+// immediately with ForceDelete. Deleting a secret that does not exist is not an error.
+func (a *AwsSecretManager) DeleteSecret(ctx context.Context, key string) error {
+	cloudy.Info(ctx, "deleting secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
 
-	input := &secretsmanager.GetSecretValueInput{
-		SecretId:     aws.String(key),
+	key, err := a.secretName(key)
+	if err != nil {
+		return err
 	}
 
-	// In this sample we only handle the specific exceptions for the 'GetSecretValue' API.
-	// See https://docs.aws.amazon.com/secretsmanager/latest/apireference/API_GetSecretValue.html
+	input := &secretsmanager.DeleteSecretInput{
+		SecretId: aws.String(key),
+	}
+	if a.ForceDelete {
+		input.ForceDeleteWithoutRecovery = aws.Bool(true)
+	} else {
+		days := a.RecoveryWindowDays
+		if days == 0 {
+			days = DefaultRecoveryWindowDays
+		}
+		input.RecoveryWindowInDays = aws.Int64(int64(days))
+	}
 
-	result, err := a.Client.GetSecretValue(input)
+	_, err = a.Client.DeleteSecret(ctx, input)
+	err = mapSecretError(ctx, "DeleteSecret", key, err)
+	if errors.Is(err, ErrSecretNotFound) || errors.Is(err, ErrSecretPendingDeletion) {
+		return nil
+	}
+	return err
+}
+
+// RestoreSecret cancels the scheduled deletion of a secret
+func (a *AwsSecretManager) RestoreSecret(ctx context.Context, key string) error {
+	cloudy.Info(ctx, "restoring secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+
+	name, err := a.secretName(key)
+	if err != nil {
+		return err
+	}
+	return a.restoreSecret(ctx, name)
+}
+
+func (a *AwsSecretManager) restoreSecret(ctx context.Context, key string) error {
+	_, err := a.Client.RestoreSecret(ctx, &secretsmanager.RestoreSecretInput{
+		SecretId: aws.String(key),
+	})
+	return mapSecretError(ctx, "RestoreSecret", key, err)
+}
+
+// recreateDeletedSecret handles a create that failed because the name is scheduled for
+// deletion, following DeletedSecretPolicy
+func (a *AwsSecretManager) recreateDeletedSecret(ctx context.Context, key string, createErr error, value *SecretValue, opts SaveOptions) (string, error) {
+	policy := a.DeletedSecretPolicy
+	if policy == "" {
+		policy = DeletedSecretRestore
+	}
+	cloudy.Info(ctx, "secret with key [%s] is scheduled for deletion, policy [%s]", key, policy)
+
+	if policy == DeletedSecretFail {
+		return "", createErr
+	}
+
+	if policy == DeletedSecretRestore {
+		err := a.restoreSecret(ctx, key)
+		if err == nil {
+			return a.putSecretValue(ctx, key, value, opts)
+		}
+		// A force deleted secret cannot be restored, wait for it to go away instead
+		if !errors.Is(err, ErrSecretNotFound) {
+			return "", err
+		}
+	}
+
+	retries := a.DeletedSecretRetries
+	if retries == 0 {
+		retries = DefaultDeletedSecretRetries
+	}
+	err := createErr
+	for n := 1; n <= retries; n++ {
+		if err := expBackoffContext(ctx, n, 32000); err != nil {
+			return "", err
+		}
+		var versionId string
+		if versionId, err = a.createSecretValue(ctx, key, value, opts); !errors.Is(err, ErrSecretPendingDeletion) {
+			return versionId, err
+		}
+	}
+	return "", err
+}
+
+// putSecretValue writes a new version of an existing secret and returns its VersionId
+func (a *AwsSecretManager) putSecretValue(ctx context.Context, key string, value *SecretValue, opts SaveOptions) (string, error) {
+	cloudy.Info(ctx, "putting secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+
+	input := &secretsmanager.PutSecretValueInput{
+		SecretId: aws.String(key),
+	}
+	if value.Binary != nil {
+		input.SecretBinary = value.Binary
+	} else {
+		input.SecretString = aws.String(value.String)
+	}
+	if len(value.Stages) > 0 {
+		input.VersionStages = value.Stages
+	}
+	if opts.ClientRequestToken != "" {
+		input.ClientRequestToken = aws.String(opts.ClientRequestToken)
+	}
+
+	result, err := a.Client.PutSecretValue(ctx, input)
+	if err != nil {
+		return "", mapSecretError(ctx, "PutSecretValue", key, err)
+	}
+	return aws.ToString(result.VersionId), nil
+}
+
+// createSecretValue creates the secret with the value as its first version
+func (a *AwsSecretManager) createSecretValue(ctx context.Context, key string, value *SecretValue, opts SaveOptions) (string, error) {
+	cloudy.Info(ctx, "creating secret with key [%s] in region [%s]", key, a.AwsCredentials.Region)
+
+	input := a.createSecretInput(key, opts.Secret)
+	if value.Binary != nil {
+		input.SecretBinary = value.Binary
+	} else {
+		input.SecretString = aws.String(value.String)
+	}
+	if opts.ClientRequestToken != "" {
+		input.ClientRequestToken = aws.String(opts.ClientRequestToken)
+	}
+
+	result, err := a.Client.CreateSecret(ctx, input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case secretsmanager.ErrCodeDecryptionFailure:
-				// Secrets Manager can't decrypt the protected secret text using the provided KMS key.
-				cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeDecryptionFailure, aerr.Error())
-
-			case secretsmanager.ErrCodeInternalServiceError:
-				// An error occurred on the server side.
-				cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeInternalServiceError, aerr.Error())
-
-			case secretsmanager.ErrCodeInvalidParameterException:
-				// You provided an invalid value for a parameter.
-				cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeInvalidParameterException, aerr.Error())
-
-			case secretsmanager.ErrCodeInvalidRequestException:
-				// You provided a parameter value that is not valid for the current state of the resource.
-				cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeInvalidRequestException, aerr.Error())
-
-			case secretsmanager.ErrCodeResourceNotFoundException:
-				// We can't find the resource that you asked for.
-				cloudy.Info(ctx, "Exc:%s, Error:%s", secretsmanager.ErrCodeResourceNotFoundException, aerr.Error())
-			default:
-                cloudy.Info(ctx, aerr.Error())
-            }
-			
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			cloudy.Info(ctx, err.Error())
+		return "", mapSecretError(ctx, "CreateSecret", key, err)
+	}
+	if opts.Secret.ResourcePolicy != "" {
+		if err := a.putSecretPolicy(ctx, key, opts.Secret.ResourcePolicy); err != nil {
+			// A secret left without its policy would make the next create fail as existing
+			if rerr := a.rollbackCreate(ctx, key, opts.Secret); rerr != nil {
+				return "", errors.Join(err, rerr)
+			}
+			return "", err
+		}
+	}
+	return aws.ToString(result.VersionId), nil
+}
+
+// rollbackCreate deletes a secret that was just created, without a recovery window.
+// Replicas have to be removed before the secret can be deleted.
+func (a *AwsSecretManager) rollbackCreate(ctx context.Context, key string, opts SecretOptions) error {
+	cloudy.Warn(ctx, "rolling back creation of secret with key [%s]", key)
+
+	if len(opts.Replicas) > 0 {
+		regions := make([]string, 0, len(opts.Replicas))
+		for _, replica := range opts.Replicas {
+			regions = append(regions, replica.Region)
+		}
+		_, err := a.Client.RemoveRegionsFromReplication(ctx, &secretsmanager.RemoveRegionsFromReplicationInput{
+			SecretId:             aws.String(key),
+			RemoveReplicaRegions: regions,
+		})
+		if err != nil {
+			return mapSecretError(ctx, "RemoveRegionsFromReplication", key, err)
 		}
-		return "", nil, err
 	}
 
-	if result.SecretString != nil {
-		return *result.SecretString, nil, err
+	_, err := a.Client.DeleteSecret(ctx, &secretsmanager.DeleteSecretInput{
+		SecretId:                   aws.String(key),
+		ForceDeleteWithoutRecovery: aws.Bool(true),
+	})
+	return mapSecretError(ctx, "DeleteSecret", key, err)
+}
+
+// getRawSecret reads the current version, or the one given by versionId or stage
+func (a *AwsSecretManager) getRawSecret(ctx context.Context, key string, versionId string, stage string) (*SecretValue, error) {
+	input := &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(key),
+	}
+	if versionId != "" {
+		input.VersionId = aws.String(versionId)
+	}
+	if stage != "" {
+		input.VersionStage = aws.String(stage)
+	}
+
+	result, err := a.Client.GetSecretValue(ctx, input)
+	if err != nil {
+		return nil, mapSecretError(ctx, "GetSecretValue", key, err)
 	}
 
-	if result.SecretBinary != nil {
-		return "", result.SecretBinary, err
+	if result.SecretString == nil && result.SecretBinary == nil {
+		return nil, cloudy.Error(ctx, "could not find SecretString or SecretBinary")
 	}
 
-	return "", nil, cloudy.Error(ctx, "could not find SecretString or SecretBinary")
+	return &SecretValue{
+		VersionId:   aws.ToString(result.VersionId),
+		Stages:      result.VersionStages,
+		CreatedDate: aws.ToTime(result.CreatedDate),
+		String:      aws.ToString(result.SecretString),
+		Binary:      result.SecretBinary,
+	}, nil
 }
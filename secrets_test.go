@@ -1,11 +1,22 @@
 package cloudyaws
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/appliedres/cloudy"
-	"github.com/appliedres/cloudy/testutil"
+	"github.com/aws/smithy-go"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -18,18 +29,10 @@ func TestSecrets(t *testing.T) {
 	
 	ctx := cloudy.StartContext()
 
-	_ = testutil.LoadEnv("../arkloud-conf/arkloud.env")
+	sm, _ := newFakeSecretManager(t)
 
-	creds := AwsCredentials{
-		Region:       cloudy.ForceEnv("AWS_REGION", ""),
-		AccessKeyID:     cloudy.ForceEnv("AWS_ACCESS_KEY_ID", ""),
-		SecretAccessKey:     cloudy.ForceEnv("AWS_SECRET_ACCESS_KEY", ""),
-	}
-
-	sm, err := NewSecretManager(ctx, creds)
-	
 	// start with a known state (deleted), will error if secret is already delted, but that's fine
-	err = sm.DeleteSecret(ctx, testSecretName)
+	err := sm.DeleteSecret(ctx, testSecretName)
 
 	// delete while non-existing, this should not produce an error
 	err = sm.DeleteSecret(ctx, testSecretName)
@@ -81,24 +84,22 @@ func TestSecrets(t *testing.T) {
 	secRaw, err = sm.GetSecret(ctx, testSecretName)
 	assert.NotNil(t, err)
 
-	// TODO: can't create/get secret immediately after deletion due to AWS async deletion
-
-	// // confirm delete worked
-	// secBin, err = sm.GetSecretBinary(ctx, testSecretName)
-	// assert.NotNil(t, err)
+	// confirm delete worked
+	secBin, err = sm.GetSecretBinary(ctx, testSecretName)
+	assert.NotNil(t, err)
 
-	// // save binary while non-existing
-	// err = sm.SaveSecretBinary(ctx, testSecretName, testSecretBinaryValue)
-	// assert.Nil(t, err)
+	// save binary while scheduled for deletion restores the secret
+	err = sm.SaveSecretBinary(ctx, testSecretName, testSecretBinaryValue)
+	assert.Nil(t, err)
 
-	// // get binary while secret exists
-	// secBin, err = sm.GetSecretBinary(ctx, testSecretName)
-	// assert.Nil(t, err)
-	// assert.Equal(t, secBin, testSecretBinaryValue)
+	// get binary while secret exists
+	secBin, err = sm.GetSecretBinary(ctx, testSecretName)
+	assert.Nil(t, err)
+	assert.Equal(t, secBin, testSecretBinaryValue)
 
-	// // final delete to clean up
-	// err = sm.DeleteSecret(ctx, testSecretName)
-	// assert.Nil(t, err)
+	// final delete to clean up
+	err = sm.DeleteSecret(ctx, testSecretName)
+	assert.Nil(t, err)
 
 }
 
@@ -182,4 +183,923 @@ func TestSecrets(t *testing.T) {
 
 // 	err = sm.DeleteSecret(ctx, ctx, testSecretName)
 // 	assert.Nil(t, err)
-// }
\ No newline at end of file
+// }
+
+// fakeSecretsManager is an in-memory Secrets Manager speaking the JSON protocol, enough to
+// exercise the provider without an AWS account.
+type fakeSecretsManager struct {
+	*httptest.Server
+	secrets []*fakeSecret
+	nextId  int
+	purging map[string]int    // Force deleted names, creates fail this many more times
+	fail    map[string]string // Error code to return from the next call of an operation
+	before  map[string]func() // Runs before the next call of an operation
+	calls   map[string]int    // Calls made of each operation
+	mu      sync.Mutex
+}
+
+type fakeSecret struct {
+	Name        string
+	Description string
+	KmsKeyId    string
+	Tags        map[string]string
+	Policy      string
+	Replicas    []string
+	Rotation    map[string]interface{} // RotationLambdaARN and RotationRules, nil when not enabled
+	Versions    []*fakeVersion
+	Deleted     bool
+}
+
+type fakeVersion struct {
+	Id      string
+	String  *string
+	Binary  []byte
+	Stages  []string
+	Created time.Time
+}
+
+// fakeSecretsError is returned by the handlers as a service error response
+type fakeSecretsError struct {
+	Code    string
+	Message string
+}
+
+func (e *fakeSecretsError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func newFakeSecretsManager(t *testing.T) *fakeSecretsManager {
+	stub := &fakeSecretsManager{purging: map[string]int{}, fail: map[string]string{}, before: map[string]func(){}, calls: map[string]int{}}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&input)
+
+		handlers := map[string]func(map[string]interface{}) (interface{}, error){
+			"ListSecrets":              stub.listSecrets,
+			"CreateSecret":             stub.createSecret,
+			"PutSecretValue":           stub.putSecretValue,
+			"GetSecretValue":           stub.getSecretValue,
+			"DescribeSecret":           stub.describeSecret,
+			"ListSecretVersionIds":     stub.listSecretVersionIds,
+			"UpdateSecretVersionStage": stub.updateSecretVersionStage,
+			"DeleteSecret":             stub.deleteSecret,
+			"RestoreSecret":            stub.restoreSecret,
+			"TagResource":              stub.tagResource,
+			"UntagResource":            stub.untagResource,
+			"GetResourcePolicy":        stub.getResourcePolicy,
+			"PutResourcePolicy":        stub.putResourcePolicy,
+			"DeleteResourcePolicy":     stub.deleteResourcePolicy,
+			"RotateSecret":             stub.rotateSecret,
+			"CancelRotateSecret":       stub.cancelRotateSecret,
+			"GetRandomPassword":        stub.getRandomPassword,
+			"BatchGetSecretValue":      stub.batchGetSecretValue,
+
+			"RemoveRegionsFromReplication": stub.removeRegionsFromReplication,
+		}
+		op := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "secretsmanager.")
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		stub.calls[op]++
+		handler, ok := handlers[op]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if hook, ok := stub.before[op]; ok {
+			delete(stub.before, op)
+			hook()
+		}
+		if code, ok := stub.fail[op]; ok {
+			delete(stub.fail, op)
+			handler = func(map[string]interface{}) (interface{}, error) {
+				return nil, &fakeSecretsError{code, "injected failure"}
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		output, err := handler(input)
+		if err != nil {
+			code := "InternalServiceError"
+			if serr, ok := err.(*fakeSecretsError); ok {
+				code = serr.Code
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"__type": code, "message": err.Error()})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(output)
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func (stub *fakeSecretsManager) find(input map[string]interface{}) (*fakeSecret, error) {
+	secret, err := stub.findDeleted(input)
+	if err == nil && secret.Deleted {
+		return nil, &fakeSecretsError{"InvalidRequestException", "You can't perform this operation on the secret because it was marked for deletion."}
+	}
+	return secret, err
+}
+
+// findDeleted also returns secrets scheduled for deletion
+func (stub *fakeSecretsManager) findDeleted(input map[string]interface{}) (*fakeSecret, error) {
+	id, _ := input["SecretId"].(string)
+	id = strings.TrimPrefix(id, fakeSecretArnPrefix)
+	for _, secret := range stub.secrets {
+		if secret.Name == id {
+			return secret, nil
+		}
+	}
+	return nil, &fakeSecretsError{"ResourceNotFoundException", "Secrets Manager can't find the specified secret."}
+}
+
+func (stub *fakeSecretsManager) removeRegionsFromReplication(input map[string]interface{}) (interface{}, error) {
+	secret, err := stub.find(input)
+	if err != nil {
+		return nil, err
+	}
+	for _, region := range input["RemoveReplicaRegions"].([]interface{}) {
+		secret.Replicas = slices.DeleteFunc(secret.Replicas, func(r string) bool { return r == region })
+	}
+	return map[string]string{"ARN": fakeSecretArnPrefix + secret.Name}, nil
+}
+
+func (stub *fakeSecretsManager) deleteSecret(input map[string]interface{}) (interface{}, error) {
+	secret, err := stub.find(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(secret.Replicas) > 0 {
+		return nil, &fakeSecretsError{"InvalidRequestException", "You can't delete secret that has replicas"}
+	}
+	if force, _ := input["ForceDeleteWithoutRecovery"].(bool); force {
+		for i, s := range stub.secrets {
+			if s == secret {
+				stub.secrets = append(stub.secrets[:i], stub.secrets[i+1:]...)
+			}
+		}
+		stub.purging[secret.Name] = 1
+		return map[string]string{"Name": secret.Name}, nil
+	}
+
+	days, _ := input["RecoveryWindowInDays"].(float64)
+	if days < 7 || days > 30 {
+		return nil, &fakeSecretsError{"InvalidParameterException", "RecoveryWindowInDays must be 7 to 30"}
+	}
+	secret.Deleted = true
+	return map[string]interface{}{"Name": secret.Name, "DeletionDate": time.Now().Unix()}, nil
+}
+
+func (stub *fakeSecretsManager) restoreSecret(input map[string]interface{}) (interface{}, error) {
+	secret, err := stub.findDeleted(input)
+	if err != nil {
+		return nil, err
+	}
+	secret.Deleted = false
+	return map[string]string{"Name": secret.Name}, nil
+}
+
+// addVersion stores a new version and moves its stages off the older versions
+func (stub *fakeSecretsManager) addVersion(secret *fakeSecret, input map[string]interface{}) *fakeVersion {
+	token, _ := input["ClientRequestToken"].(string)
+	for _, v := range secret.Versions {
+		if v.Id == token && (v.String != nil || v.Binary != nil) {
+			return v
+		}
+		if v.Id == token {
+			// Registered by RotateSecret, the rotation function puts its value
+			secret.Versions = removeVersion(secret.Versions, v)
+		}
+	}
+
+	stub.nextId++
+	if token == "" {
+		token = fmt.Sprintf("%032d", stub.nextId)
+	}
+	version := &fakeVersion{
+		Id:      token,
+		Created: time.Unix(int64(1700000000+stub.nextId), 0),
+		Stages:  []string{StageCurrent},
+	}
+	if str, ok := input["SecretString"].(string); ok {
+		version.String = &str
+	}
+	if bin, ok := input["SecretBinary"].(string); ok {
+		version.Binary, _ = base64.StdEncoding.DecodeString(bin)
+	}
+	if stages, ok := input["VersionStages"].([]interface{}); ok {
+		version.Stages = nil
+		for _, stage := range stages {
+			version.Stages = append(version.Stages, stage.(string))
+		}
+	}
+
+	for _, stage := range version.Stages {
+		stub.moveStage(secret, stage, version)
+	}
+	secret.Versions = append(secret.Versions, version)
+	return version
+}
+
+// moveStage attaches a stage to a version, moving AWSPREVIOUS along with AWSCURRENT
+func (stub *fakeSecretsManager) moveStage(secret *fakeSecret, stage string, to *fakeVersion) {
+	for _, v := range secret.Versions {
+		if v != to && removeStage(v, stage) && stage == StageCurrent {
+			stub.moveStage(secret, StagePrevious, v)
+		}
+	}
+	if !hasStage(to, stage) {
+		to.Stages = append(to.Stages, stage)
+	}
+}
+
+func hasStage(v *fakeVersion, stage string) bool {
+	for _, s := range v.Stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+func removeStage(v *fakeVersion, stage string) bool {
+	for i, s := range v.Stages {
+		if s == stage {
+			v.Stages = append(v.Stages[:i], v.Stages[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (stub *fakeSecretsManager) createSecret(input map[string]interface{}) (interface{}, error) {
+	name, _ := input["Name"].(string)
+	if secret, err := stub.findDeleted(map[string]interface{}{"SecretId": name}); err == nil {
+		if secret.Deleted {
+			return nil, &fakeSecretsError{"InvalidRequestException", "You can't create this secret because a secret with this name is already scheduled for deletion."}
+		}
+		return nil, &fakeSecretsError{"ResourceExistsException", "The operation failed because the secret " + name + " already exists."}
+	}
+	if stub.purging[name] > 0 {
+		stub.purging[name]--
+		return nil, &fakeSecretsError{"InvalidRequestException", "You can't create this secret because a secret with this name is already scheduled for deletion."}
+	}
+
+	secret := &fakeSecret{Name: name, Tags: map[string]string{}}
+	secret.Description, _ = input["Description"].(string)
+	secret.KmsKeyId, _ = input["KmsKeyId"].(string)
+	stub.setTags(secret, input["Tags"])
+	if replicas, ok := input["AddReplicaRegions"].([]interface{}); ok {
+		for _, r := range replicas {
+			secret.Replicas = append(secret.Replicas, r.(map[string]interface{})["Region"].(string))
+		}
+	}
+	stub.secrets = append(stub.secrets, secret)
+	version := stub.addVersion(secret, input)
+	return map[string]string{"Name": name, "VersionId": version.Id}, nil
+}
+
+func (stub *fakeSecretsManager) putSecretValue(input map[string]interface{}) (interface{}, error) {
+	secret, err := stub.find(input)
+	if err != nil {
+		return nil, err
+	}
+	version := stub.addVersion(secret, input)
+	return map[string]interface{}{"Name": secret.Name, "VersionId": version.Id, "VersionStages": version.Stages}, nil
+}
+
+func (stub *fakeSecretsManager) getSecretValue(input map[string]interface{}) (interface{}, error) {
+	secret, err := stub.find(input)
+	if err != nil {
+		return nil, err
+	}
+
+	versionId, _ := input["VersionId"].(string)
+	stage, _ := input["VersionStage"].(string)
+	if versionId == "" && stage == "" {
+		stage = StageCurrent
+	}
+	for _, v := range secret.Versions {
+		if v.String == nil && v.Binary == nil {
+			// Registered by RotateSecret but not put yet, AWS does not find it either
+			continue
+		}
+		if (versionId == "" || v.Id == versionId) && (stage == "" || hasStage(v, stage)) {
+			output := map[string]interface{}{
+				"Name":          secret.Name,
+				"VersionId":     v.Id,
+				"VersionStages": v.Stages,
+				"CreatedDate":   v.Created.Unix(),
+			}
+			if v.String != nil {
+				output["SecretString"] = *v.String
+			}
+			if v.Binary != nil {
+				output["SecretBinary"] = v.Binary
+			}
+			return output, nil
+		}
+	}
+	return nil, &fakeSecretsError{"ResourceNotFoundException", "Secrets Manager can't find the specified secret value."}
+}
+
+const fakeSecretArnPrefix = "arn:aws:secretsmanager:us-east-1:123456789012:secret:"
+
+func (stub *fakeSecretsManager) batchGetSecretValue(input map[string]interface{}) (interface{}, error) {
+	ids, _ := input["SecretIdList"].([]interface{})
+	if len(ids) > 20 {
+		return nil, &fakeSecretsError{"InvalidParameterException", "SecretIdList can contain at most 20 secrets."}
+	}
+
+	values := []interface{}{}
+	errs := []interface{}{}
+	for _, id := range ids {
+		name := strings.TrimPrefix(id.(string), fakeSecretArnPrefix)
+		output, err := stub.getSecretValue(map[string]interface{}{"SecretId": name})
+		if err != nil {
+			serr := err.(*fakeSecretsError)
+			errs = append(errs, map[string]string{"SecretId": id.(string), "ErrorCode": serr.Code, "Message": serr.Message})
+			continue
+		}
+		value := output.(map[string]interface{})
+		value["ARN"] = fakeSecretArnPrefix + name
+		values = append(values, value)
+	}
+	return map[string]interface{}{"SecretValues": values, "Errors": errs}, nil
+}
+
+func (stub *fakeSecretsManager) describeSecret(input map[string]interface{}) (interface{}, error) {
+	secret, err := stub.find(input)
+	if err != nil {
+		return nil, err
+	}
+	stages := map[string][]string{}
+	for _, v := range secret.Versions {
+		if len(v.Stages) > 0 {
+			stages[v.Id] = v.Stages
+		}
+	}
+	var tags []map[string]string
+	for k, v := range secret.Tags {
+		tags = append(tags, map[string]string{"Key": k, "Value": v})
+	}
+	output := map[string]interface{}{"Name": secret.Name, "Description": secret.Description, "Tags": tags, "VersionIdsToStages": stages}
+	if secret.Rotation != nil {
+		output["RotationEnabled"] = true
+		output["RotationLambdaARN"] = secret.Rotation["RotationLambdaARN"]
+		output["RotationRules"] = secret.Rotation["RotationRules"]
+	}
+	return output, nil
+}
+
+func (stub *fakeSecretsManager) rotateSecret(input map[string]interface{}) (interface{}, error) {
+	secret, err := stub.find(input)
+	if err != nil {
+		return nil, err
+	}
+	if lambda, ok := input["RotationLambdaARN"]; ok {
+		secret.Rotation = map[string]interface{}{"RotationLambdaARN": lambda, "RotationRules": input["RotationRules"]}
+	}
+	if secret.Rotation == nil {
+		return nil, &fakeSecretsError{"InvalidRequestException", "No Lambda rotation function ARN is associated with this secret."}
+	}
+	if now, ok := input["RotateImmediately"].(bool); ok && !now {
+		return map[string]string{"Name": secret.Name}, nil
+	}
+
+	// The version is registered as AWSPENDING before the rotation function is invoked
+	stub.nextId++
+	version := &fakeVersion{Id: fmt.Sprintf("%032d", stub.nextId), Stages: []string{StagePending}, Created: time.Unix(int64(1700000000+stub.nextId), 0)}
+	stub.moveStage(secret, StagePending, version)
+	secret.Versions = append(secret.Versions, version)
+	return map[string]string{"Name": secret.Name, "VersionId": version.Id}, nil
+}
+
+func (stub *fakeSecretsManager) cancelRotateSecret(input map[string]interface{}) (interface{}, error) {
+	secret, err := stub.find(input)
+	if err != nil {
+		return nil, err
+	}
+	secret.Rotation = nil
+	return map[string]string{"Name": secret.Name}, nil
+}
+
+func (stub *fakeSecretsManager) getRandomPassword(input map[string]interface{}) (interface{}, error) {
+	stub.nextId++
+	length := int(input["PasswordLength"].(float64))
+	return map[string]string{"RandomPassword": fmt.Sprintf("%0*d", length, stub.nextId)}, nil
+}
+
+func removeVersion(versions []*fakeVersion, version *fakeVersion) []*fakeVersion {
+	var result []*fakeVersion
+	for _, v := range versions {
+		if v != version {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func (stub *fakeSecretsManager) setTags(secret *fakeSecret, tags interface{}) {
+	list, _ := tags.([]interface{})
+	for _, t := range list {
+		tag := t.(map[string]interface{})
+		secret.Tags[tag["Key"].(string)] = tag["Value"].(string)
+	}
+}
+
+func (stub *fakeSecretsManager) tagResource(input map[string]interface{}) (interface{}, error) {
+	secret, err := stub.find(input)
+	if err != nil {
+		return nil, err
+	}
+	if secret.Tags == nil {
+		secret.Tags = map[string]string{}
+	}
+	stub.setTags(secret, input["Tags"])
+	return map[string]string{}, nil
+}
+
+func (stub *fakeSecretsManager) untagResource(input map[string]interface{}) (interface{}, error) {
+	secret, err := stub.find(input)
+	if err != nil {
+		return nil, err
+	}
+	keys, _ := input["TagKeys"].([]interface{})
+	for _, k := range keys {
+		delete(secret.Tags, k.(string))
+	}
+	return map[string]string{}, nil
+}
+
+func (stub *fakeSecretsManager) getResourcePolicy(input map[string]interface{}) (interface{}, error) {
+	secret, err := stub.find(input)
+	if err != nil {
+		return nil, err
+	}
+	output := map[string]string{"Name": secret.Name}
+	if secret.Policy != "" {
+		output["ResourcePolicy"] = secret.Policy
+	}
+	return output, nil
+}
+
+func (stub *fakeSecretsManager) putResourcePolicy(input map[string]interface{}) (interface{}, error) {
+	secret, err := stub.find(input)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := input["BlockPublicPolicy"].(bool); block && strings.Contains(input["ResourcePolicy"].(string), `"Principal":"*"`) {
+		return nil, &fakeSecretsError{"PublicPolicyException", "The BlockPublicPolicy parameter is set to true, and the resource policy did not prevent broad access to the secret."}
+	}
+	secret.Policy = input["ResourcePolicy"].(string)
+	return map[string]string{"Name": secret.Name}, nil
+}
+
+func (stub *fakeSecretsManager) deleteResourcePolicy(input map[string]interface{}) (interface{}, error) {
+	secret, err := stub.find(input)
+	if err != nil {
+		return nil, err
+	}
+	secret.Policy = ""
+	return map[string]string{"Name": secret.Name}, nil
+}
+
+func (stub *fakeSecretsManager) listSecretVersionIds(input map[string]interface{}) (interface{}, error) {
+	secret, err := stub.find(input)
+	if err != nil {
+		return nil, err
+	}
+	includeDeprecated, _ := input["IncludeDeprecated"].(bool)
+
+	var versions []map[string]interface{}
+	for _, v := range secret.Versions {
+		if len(v.Stages) > 0 || includeDeprecated {
+			versions = append(versions, map[string]interface{}{"VersionId": v.Id, "VersionStages": v.Stages, "CreatedDate": v.Created.Unix()})
+		}
+	}
+	return map[string]interface{}{"Name": secret.Name, "Versions": versions}, nil
+}
+
+func (stub *fakeSecretsManager) updateSecretVersionStage(input map[string]interface{}) (interface{}, error) {
+	secret, err := stub.find(input)
+	if err != nil {
+		return nil, err
+	}
+	stage, _ := input["VersionStage"].(string)
+	moveTo, _ := input["MoveToVersionId"].(string)
+	removeFrom, _ := input["RemoveFromVersionId"].(string)
+
+	for _, v := range secret.Versions {
+		if hasStage(v, stage) && v.Id != removeFrom && v.Id != moveTo {
+			return nil, &fakeSecretsError{"InvalidParameterException", "The staging label is attached to a different version " + v.Id}
+		}
+		if v.Id == removeFrom && !hasStage(v, stage) {
+			return nil, &fakeSecretsError{"InvalidParameterException", "The staging label is not attached to version " + v.Id}
+		}
+	}
+	if moveTo == "" {
+		for _, v := range secret.Versions {
+			if v.Id == removeFrom {
+				removeStage(v, stage)
+			}
+		}
+		return map[string]string{"Name": secret.Name}, nil
+	}
+	for _, v := range secret.Versions {
+		if v.Id == moveTo {
+			stub.moveStage(secret, stage, v)
+			return map[string]string{"Name": secret.Name}, nil
+		}
+	}
+	return nil, &fakeSecretsError{"ResourceNotFoundException", "Secrets Manager can't find the specified version."}
+}
+
+func (stub *fakeSecretsManager) listSecrets(input map[string]interface{}) (interface{}, error) {
+	var matched []map[string]string
+	for _, secret := range stub.secrets {
+		if !secret.Deleted && stub.matches(secret, input["Filters"]) {
+			matched = append(matched, map[string]string{"Name": secret.Name})
+		}
+	}
+
+	start, _ := strconv.Atoi(fmt.Sprint(input["NextToken"]))
+	size := 2 // Small default so ListSecrets has to follow NextToken
+	if max, ok := input["MaxResults"].(float64); ok {
+		size = int(max)
+	}
+	end := start + size
+	output := map[string]interface{}{}
+	if end < len(matched) {
+		output["NextToken"] = strconv.Itoa(end)
+	} else {
+		end = len(matched)
+	}
+	output["SecretList"] = matched[start:end]
+	return output, nil
+}
+
+func (stub *fakeSecretsManager) matches(secret *fakeSecret, filters interface{}) bool {
+	list, _ := filters.([]interface{})
+	for _, f := range list {
+		filter := f.(map[string]interface{})
+		value := filter["Values"].([]interface{})[0].(string)
+		switch filter["Key"] {
+		case "name":
+			// Secrets Manager matches names case-insensitively
+			if !strings.HasPrefix(strings.ToLower(secret.Name), strings.ToLower(value)) {
+				return false
+			}
+		case "description":
+			if !strings.HasPrefix(secret.Description, value) {
+				return false
+			}
+		case "tag-key":
+			if _, ok := secret.Tags[value]; !ok {
+				return false
+			}
+		case "tag-value":
+			found := false
+			for _, v := range secret.Tags {
+				found = found || v == value
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func newFakeSecretManager(t *testing.T, configure ...func(*AwsSecretManagerConfig)) (*AwsSecretManager, *fakeSecretsManager) {
+	isolateAwsEnv(t)
+	stub := newFakeSecretsManager(t)
+
+	cfg := AwsSecretManagerConfig{
+		AwsCredentials: AwsCredentials{
+			Type:            CredTypeSecret,
+			Region:          "us-east-1",
+			AccessKeyID:     "AKID",
+			SecretAccessKey: "secret",
+			Endpoints:       map[string]string{"secretsmanager": stub.URL},
+		},
+	}
+	for _, fn := range configure {
+		fn(&cfg)
+	}
+
+	sm, err := NewSecretManagerFromConfig(context.Background(), cfg)
+	assert.Nil(t, err)
+	return sm, stub
+}
+
+func TestListSecrets(t *testing.T) {
+	ctx := context.Background()
+	sm, stub := newFakeSecretManager(t)
+	stub.secrets = []*fakeSecret{
+		{Name: "app/db", Description: "database login", Tags: map[string]string{"team": "core"}},
+		{Name: "app/api", Description: "api key", Tags: map[string]string{"team": "edge"}},
+		{Name: "app/cache", Description: "database cache"},
+		{Name: "ops/pager", Tags: map[string]string{"oncall": "core"}},
+		{Name: "ops/backup"},
+	}
+
+	// Every page is followed
+	all, err := sm.ListAll(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"app/db", "app/api", "app/cache", "ops/pager", "ops/backup"}, all)
+
+	names, err := sm.ListSecrets(ctx, SecretFilter{NamePrefix: "app/"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"app/db", "app/api", "app/cache"}, names)
+
+	names, err = sm.ListSecrets(ctx, SecretFilter{Description: "database"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"app/db", "app/cache"}, names)
+
+	names, err = sm.ListSecrets(ctx, SecretFilter{TagValue: "core"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"app/db", "ops/pager"}, names)
+
+	names, err = sm.ListSecrets(ctx, SecretFilter{NamePrefix: "app/", TagKey: "team"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"app/db", "app/api"}, names)
+
+	page, err := sm.ListSecretsPage(ctx, SecretFilter{}, "", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"app/db", "app/api", "app/cache"}, page.Names)
+	assert.NotEmpty(t, page.NextToken)
+
+	page, err = sm.ListSecretsPage(ctx, SecretFilter{}, page.NextToken, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ops/pager", "ops/backup"}, page.Names)
+	assert.Empty(t, page.NextToken)
+}
+
+func TestSecretVersions(t *testing.T) {
+	ctx := context.Background()
+	sm, _ := newFakeSecretManager(t)
+
+	assert.Nil(t, sm.SaveSecret(ctx, "app/db", "v1"))
+	assert.Nil(t, sm.SaveSecret(ctx, "app/db", "v2"))
+
+	current, err := sm.GetSecretStage(ctx, "app/db", StageCurrent)
+	assert.Nil(t, err)
+	assert.Equal(t, "v2", current.String)
+	assert.Contains(t, current.Stages, StageCurrent)
+
+	previous, err := sm.GetSecretStage(ctx, "app/db", StagePrevious)
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", previous.String)
+
+	// A pending version is not current until promoted
+	pendingId, err := sm.SaveSecretVersion(ctx, "app/db", &SecretValue{String: "v3", Stages: []string{StagePending}})
+	assert.Nil(t, err)
+	str, err := sm.GetSecret(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, "v2", str)
+
+	pending, err := sm.GetSecretVersion(ctx, "app/db", pendingId)
+	assert.Nil(t, err)
+	assert.Equal(t, "v3", pending.String)
+	assert.Equal(t, []string{StagePending}, pending.Stages)
+
+	versions, err := sm.ListSecretVersions(ctx, "app/db", false)
+	assert.Nil(t, err)
+	assert.Len(t, versions, 3)
+	assert.Equal(t, pendingId, versions[0].VersionId)
+
+	assert.Nil(t, sm.PromoteSecretVersion(ctx, "app/db", pendingId))
+	str, err = sm.GetSecret(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, "v3", str)
+
+	// Rollback returns to v2, the version that was current before the promotion
+	assert.Nil(t, sm.RollbackSecret(ctx, "app/db"))
+	str, err = sm.GetSecret(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, "v2", str)
+
+	// v1 lost its label and is only listed as deprecated
+	versions, err = sm.ListSecretVersions(ctx, "app/db", false)
+	assert.Nil(t, err)
+	assert.Len(t, versions, 2)
+	versions, err = sm.ListSecretVersions(ctx, "app/db", true)
+	assert.Nil(t, err)
+	assert.Len(t, versions, 3)
+
+	// Binary versions
+	binId, err := sm.SaveSecretVersion(ctx, "app/db", &SecretValue{Binary: []byte{0, 1, 2}})
+	assert.Nil(t, err)
+	bin, err := sm.GetSecretBinary(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0, 1, 2}, bin)
+	value, err := sm.GetSecretVersion(ctx, "app/db", binId)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0, 1, 2}, value.Binary)
+}
+
+func TestDeleteAndRestoreSecret(t *testing.T) {
+	ctx := context.Background()
+	sm, stub := newFakeSecretManager(t)
+
+	// Deleting a missing secret is not an error
+	assert.Nil(t, sm.DeleteSecret(ctx, "app/db"))
+
+	assert.Nil(t, sm.SaveSecret(ctx, "app/db", "v1"))
+	assert.Nil(t, sm.DeleteSecret(ctx, "app/db"))
+	assert.True(t, stub.secrets[0].Deleted)
+	_, err := sm.GetSecret(ctx, "app/db")
+	assert.NotNil(t, err)
+
+	// Deleting twice is not an error either
+	assert.Nil(t, sm.DeleteSecret(ctx, "app/db"))
+
+	assert.Nil(t, sm.RestoreSecret(ctx, "app/db"))
+	str, err := sm.GetSecret(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", str)
+
+	// The default policy restores and overwrites
+	assert.Nil(t, sm.DeleteSecret(ctx, "app/db"))
+	assert.Nil(t, sm.SaveSecret(ctx, "app/db", "v2"))
+	str, err = sm.GetSecret(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, "v2", str)
+}
+
+func TestDeletedSecretPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	sm, _ := newFakeSecretManager(t, func(cfg *AwsSecretManagerConfig) {
+		cfg.DeletedSecretPolicy = DeletedSecretFail
+	})
+	assert.Nil(t, sm.SaveSecret(ctx, "app/db", "v1"))
+	assert.Nil(t, sm.DeleteSecret(ctx, "app/db"))
+	err := sm.SaveSecret(ctx, "app/db", "v2")
+	assert.True(t, errors.Is(err, ErrSecretPendingDeletion))
+
+	// A force deleted secret takes a while to disappear, retry until it has
+	sm, stub := newFakeSecretManager(t, func(cfg *AwsSecretManagerConfig) {
+		cfg.DeletedSecretPolicy = DeletedSecretRetry
+		cfg.ForceDelete = true
+	})
+	assert.Nil(t, sm.SaveSecret(ctx, "app/db", "v1"))
+	assert.Nil(t, sm.DeleteSecret(ctx, "app/db"))
+	assert.Nil(t, sm.SaveSecretBinary(ctx, "app/db", []byte("v2")))
+	bin, err := sm.GetSecretBinary(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), bin)
+
+	// Waiting for it stops with the context
+	assert.Nil(t, sm.DeleteSecret(ctx, "app/db"))
+	stub.purging["app/db"] = 100
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = sm.SaveSecret(timeout, "app/db", "v3")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, time.Since(start), time.Second)
+
+	_, err = NewSecretManagerFromConfig(ctx, AwsSecretManagerConfig{RecoveryWindowDays: 3})
+	assert.NotNil(t, err)
+	_, err = NewSecretManagerFromConfig(ctx, AwsSecretManagerConfig{DeletedSecretPolicy: "ignore"})
+	assert.NotNil(t, err)
+}
+
+func TestSecretErrors(t *testing.T) {
+	ctx := context.Background()
+	sm, stub := newFakeSecretManager(t, func(cfg *AwsSecretManagerConfig) {
+		cfg.MaxAttempts = 1
+	})
+
+	_, err := sm.GetSecret(ctx, "app/db")
+	assert.True(t, errors.Is(err, ErrSecretNotFound))
+	var secretErr *SecretError
+	assert.True(t, errors.As(err, &secretErr))
+	assert.Equal(t, "GetSecretValue", secretErr.Op)
+	assert.Equal(t, "app/db", secretErr.Key)
+	var apiErr smithy.APIError
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "ResourceNotFoundException", apiErr.ErrorCode())
+
+	assert.Nil(t, sm.SaveSecret(ctx, "app/db", "v1"))
+	_, err = sm.createSecretValue(ctx, "app/db", &SecretValue{String: "v1"}, SaveOptions{})
+	assert.True(t, errors.Is(err, ErrSecretExists))
+
+	tests := []struct {
+		op   string
+		code string
+		kind error
+	}{
+		{"GetSecretValue", "DecryptionFailure", ErrDecryption},
+		{"GetSecretValue", "AccessDeniedException", ErrAccessDenied},
+		{"GetSecretValue", "ThrottlingException", ErrThrottled},
+		{"PutSecretValue", "EncryptionFailure", ErrEncryption},
+		{"ListSecrets", "ThrottlingException", ErrThrottled},
+	}
+	for _, tt := range tests {
+		stub.fail[tt.op] = tt.code
+		switch tt.op {
+		case "GetSecretValue":
+			_, err = sm.GetSecret(ctx, "app/db")
+		case "PutSecretValue":
+			_, err = sm.putSecretValue(ctx, "app/db", &SecretValue{String: "v2"}, SaveOptions{})
+		case "ListSecrets":
+			_, err = sm.ListAll(ctx)
+		}
+		assert.True(t, errors.Is(err, tt.kind), "%v %v: %v", tt.op, tt.code, err)
+	}
+
+	// Uncategorised failures still wrap the SDK error
+	stub.fail["GetSecretValue"] = "InvalidParameterException"
+	_, err = sm.GetSecret(ctx, "app/db")
+	assert.True(t, errors.As(err, &secretErr))
+	assert.Nil(t, secretErr.Kind)
+	assert.True(t, errors.As(err, &apiErr))
+}
+
+func TestSaveSecretUpsert(t *testing.T) {
+	ctx := context.Background()
+	sm, stub := newFakeSecretManager(t, func(cfg *AwsSecretManagerConfig) {
+		cfg.MaxAttempts = 1
+	})
+
+	// Only an existing secret falls back to a put
+	stub.fail["CreateSecret"] = "EncryptionFailure"
+	err := sm.SaveSecret(ctx, "app/db", "v1")
+	assert.True(t, errors.Is(err, ErrEncryption))
+	assert.Empty(t, stub.secrets)
+
+	assert.Nil(t, sm.SaveSecret(ctx, "app/db", "v1"))
+	assert.Nil(t, sm.SaveSecret(ctx, "app/db", "v2"))
+	str, err := sm.GetSecret(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, "v2", str)
+
+	// The same token does not create a second version
+	token := "11111111-2222-3333-4444-555555555555"
+	id1, err := sm.SaveSecretWithOptions(ctx, "app/db", &SecretValue{String: "v3"}, SaveOptions{ClientRequestToken: token})
+	assert.Nil(t, err)
+	id2, err := sm.SaveSecretWithOptions(ctx, "app/db", &SecretValue{String: "v3"}, SaveOptions{ClientRequestToken: token})
+	assert.Nil(t, err)
+	assert.Equal(t, token, id1)
+	assert.Equal(t, id1, id2)
+	assert.Len(t, stub.secrets[0].Versions, 3)
+}
+
+func TestSaveSecretCompareAndSwap(t *testing.T) {
+	ctx := context.Background()
+	sm, stub := newFakeSecretManager(t)
+
+	assert.Nil(t, sm.SaveSecret(ctx, "app/db", "v1"))
+	current, err := sm.GetSecretStage(ctx, "app/db", StageCurrent)
+	assert.Nil(t, err)
+
+	newId, err := sm.SaveSecretWithOptions(ctx, "app/db", &SecretValue{String: "v2"}, SaveOptions{ExpectedVersionId: current.VersionId})
+	assert.Nil(t, err)
+	value, err := sm.GetSecretStage(ctx, "app/db", StageCurrent)
+	assert.Nil(t, err)
+	assert.Equal(t, "v2", value.String)
+	assert.Equal(t, newId, value.VersionId)
+	// The private label is cleaned up
+	assert.Equal(t, []string{StageCurrent}, value.Stages)
+
+	// A stale expected version loses
+	_, err = sm.SaveSecretWithOptions(ctx, "app/db", &SecretValue{String: "v3"}, SaveOptions{ExpectedVersionId: current.VersionId})
+	assert.True(t, errors.Is(err, ErrVersionConflict))
+	str, err := sm.GetSecret(ctx, "app/db")
+	assert.Nil(t, err)
+	assert.Equal(t, "v2", str)
+
+	// Retrying a swap that succeeded returns the same version
+	token := "11111111-2222-3333-4444-555555555555"
+	opts := SaveOptions{ExpectedVersionId: newId, ClientRequestToken: token}
+	id1, err := sm.SaveSecretWithOptions(ctx, "app/db", &SecretValue{String: "v4"}, opts)
+	assert.Nil(t, err)
+	id2, err := sm.SaveSecretWithOptions(ctx, "app/db", &SecretValue{String: "v4"}, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, token, id1)
+	assert.Equal(t, id1, id2)
+
+	// Another writer moving AWSCURRENT between the check and the swap is a conflict too
+	stub.fail["UpdateSecretVersionStage"] = "InvalidParameterException"
+	_, err = sm.SaveSecretWithOptions(ctx, "app/db", &SecretValue{String: "v5"}, SaveOptions{ExpectedVersionId: "stale"})
+	assert.True(t, errors.Is(err, ErrVersionConflict))
+}
+
+func TestSecretManagerContext(t *testing.T) {
+	sm, stub := newFakeSecretManager(t)
+	assert.Nil(t, sm.SaveSecret(context.Background(), "app/db", "v1"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := sm.GetSecret(ctx, "app/db")
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	stub.before["GetSecretValue"] = func() { time.Sleep(200 * time.Millisecond) }
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = sm.GetSecret(ctx, "app/db")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
@@ -1,8 +1,9 @@
 package cloudyaws
 
 import (
+	"context"
+
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
 )
 
@@ -12,15 +13,19 @@ type Queue struct {
 }
 
 //NewQueue creates a new Queue wrapper
-func NewQueue() *Queue {
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
+func NewQueue(ctx context.Context, creds *AwsCredentials) (*Queue, error) {
+	sess, cfg, err := newAwsSessionConfig(ctx, creds)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateOnConfigure(ctx, creds, cfg, QueueActions); err != nil {
+		return nil, err
+	}
 	svc := sqs.New(sess)
 
 	return &Queue{
 		Client: svc,
-	}
+	}, nil
 }
 
 //Recieve get messages off the topic queue
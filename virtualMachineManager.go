@@ -4,7 +4,7 @@ import (
 	"context"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 
 	"github.com/appliedres/cloudy/logging"
@@ -43,20 +43,19 @@ func NewAwsVirtualMachineManager(ctx context.Context, credentials *AwsCredential
 }
 
 func (vmm *AwsVirtualMachineManager) Configure(ctx context.Context) error {
-	credProvider, err := NewAwsCredentials(vmm.credentials)
+	cfg, err := NewAwsConfig(ctx, vmm.credentials)
 	if err != nil {
 		return err
 	}
-
-	cfg, err := config.LoadDefaultConfig(ctx,
-		config.WithRegion(vmm.credentials.Region),
-		config.WithCredentialsProvider(credProvider),
-	)
-	if err != nil {
+	if err := validateOnConfigure(ctx, vmm.credentials, cfg, VirtualMachineManagerActions); err != nil {
 		return err
 	}
 
-	vmm.vmClient = ec2.NewFromConfig(cfg)
+	vmm.vmClient = ec2.NewFromConfig(cfg, func(o *ec2.Options) {
+		if url := vmm.credentials.EndpointFor(ec2.ServiceID); url != "" {
+			o.BaseEndpoint = aws.String(url)
+		}
+	})
 
 	return nil
 }
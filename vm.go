@@ -9,10 +9,8 @@ import (
 	cloudyvm "github.com/appliedres/cloudy/vm"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/servicequotas"
-	"github.com/aws/aws-sdk-go/aws/credentials"
 )
 
 const Aws = "aws"
@@ -62,7 +60,11 @@ func (f *AwsEc2ControllerFactory) FromEnv(env *cloudy.Environment) (interface{},
 
 	// TODO: confirm all necessary config items are added
 
-	cfg.AwsCredentials = GetAwsCredentialsFromEnv(env)
+	creds, err := GetAwsCredentialsFromEnv(env)
+	if err != nil {
+		return nil, err
+	}
+	cfg.AwsCredentials = creds
 
 	// cfg.SaltCmd = env.Force(("SALT_CMD"))
 
@@ -91,21 +93,14 @@ func (f *AwsEc2ControllerFactory) FromEnv(env *cloudy.Environment) (interface{},
 }
 
 func NewAwsEc2Controller(ctx context.Context, config *AwsEc2ControllerConfig) (*AwsEc2Controller, error) {
-    sess, err := session.NewSessionWithOptions(session.Options{
-        Config: aws.Config{
-			Credentials: credentials.NewStaticCredentials(
-				config.AwsCredentials.AccessKeyID, 
-				config.AwsCredentials.SecretAccessKey, 
-				"",
-			),
-			Region:      aws.String(config.AwsCredentials.Region),
-        },
-    })
+	sess, cfg, err := newAwsSessionConfig(ctx, &config.AwsCredentials)
 	if err != nil {
 		return nil, err
 	}
 
-	// TODO: add AWS permissions check before making requests
+	if err := validateOnConfigure(ctx, &config.AwsCredentials, cfg, Ec2ControllerActions); err != nil {
+		return nil, err
+	}
 
 	quotas := servicequotas.New(sess)
 	ec2client := ec2.New(sess)